@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"strings"
+
+	"hw1_tree_cmd/walk"
+)
+
+const dateLayout = "2006-01-02 15:04"
+
+// columns are the metadata printed before the tree
+type columns struct {
+	perms bool
+	user  bool
+	group bool
+	date  bool
+	hash  string // algorithm, empty for no digest
+}
+
+func (c columns) any() bool {
+	return c.perms || c.user || c.group || c.date || c.hash != ""
+}
+
+func pad(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// lsMode is the mode like ls prints it, always 10 letters with the setuid, setgid and sticky bits
+// in the places of x, fs.FileMode.String puts them before the type and widens the column
+func lsMode(m fs.FileMode) string {
+	mode := []byte("-rwxrwxrwx")
+	switch {
+	case m&fs.ModeDir != 0:
+		mode[0] = 'd'
+	case m&fs.ModeSymlink != 0:
+		mode[0] = 'l'
+	case m&fs.ModeNamedPipe != 0:
+		mode[0] = 'p'
+	case m&fs.ModeSocket != 0:
+		mode[0] = 's'
+	case m&fs.ModeCharDevice != 0:
+		mode[0] = 'c'
+	case m&fs.ModeDevice != 0:
+		mode[0] = 'b'
+	}
+	for i := 0; i < 9; i++ {
+		if m&(1<<uint(8-i)) == 0 {
+			mode[i+1] = '-'
+		}
+	}
+	for _, special := range []struct {
+		bit  fs.FileMode
+		at   int
+		char byte
+	}{{fs.ModeSetuid, 3, 's'}, {fs.ModeSetgid, 6, 's'}, {fs.ModeSticky, 9, 't'}} {
+		if m&special.bit == 0 {
+			continue
+		}
+		if mode[special.at] == '-' {
+			mode[special.at] = special.char - 'a' + 'A' // set without x
+		} else {
+			mode[special.at] = special.char
+		}
+	}
+	return string(mode)
+}
+
+// format makes the columns of f, the mode, the time and the digest have fixed widths.
+// The names are padded to 8 like ls and tree do, a longer one shifts the rest of its row.
+func (c columns) format(f walk.Entry) string {
+	var cols []string
+	if c.perms {
+		cols = append(cols, lsMode(f.Mode))
+	}
+	if c.user {
+		cols = append(cols, pad(f.User, 8))
+	}
+	if c.group {
+		cols = append(cols, pad(f.Group, 8))
+	}
+	if c.date {
+		cols = append(cols, f.ModTime.Format(dateLayout))
+	}
+	if c.hash != "" {
+		cols = append(cols, pad(f.Hash, hex.EncodedLen(walk.Hashes[c.hash]().Size())))
+	}
+	return strings.Join(cols, " ") + "  "
+}
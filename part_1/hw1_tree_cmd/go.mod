@@ -0,0 +1,3 @@
+module hw1_tree_cmd
+
+go 1.25
@@ -1,140 +1,291 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"sort"
-	"strconv"
+	"strings"
+
+	"hw1_tree_cmd/walk"
 )
 
 type mainVars struct {
-	out             io.Writer
-	printFiles      bool
-	offsetPrintable int
-	offsetTab       int
+	out      io.Writer
+	opts     walk.Options
+	format   string
+	baseURL  string // links of the files in the html
+	visitor  walk.Visitor
+	color    bool
+	watch    bool
+	human    bool
+	report   *treeReport // nil when the report is off
+	diffWith string      // the second tree of --diff
+	columns  columns
+	failures *int // entries printed with an error, shared by the whole walk
 }
 
-type fileDir struct {
-	fileName string
-	bytes    string
-	isDir    bool
+type treeReport struct {
+	dirs  int
+	files int
+	size  int64
+
+	added   int
+	removed int
+	changed int
 }
 
-func printTree(dirs *[]fileDir, v *mainVars) error {
-	var absPath, printPath string
-	l := len(*dirs) - 1
-	if l == 0 {
-		return nil // only one path, no files to print
-	}
-	if (*dirs)[0].fileName != "/" {
-		absPath = (*dirs)[0].fileName
+// errorText is the error without the path, the tree shows the entry already
+func errorText(err error) string {
+	var we *walk.Error
+	var pe *fs.PathError
+	if errors.As(err, &we) && errors.As(we.Err, &pe) {
+		return "error " + we.Op + ": " + pe.Err.Error()
 	}
+	return err.Error()
+}
 
-	off := "│	"
-	for n := 1; n <= l; n++ {
-		if n == l {
-			printPath = "└───" + (*dirs)[n].fileName + (*dirs)[n].bytes
-		} else {
-			printPath = "├───" + (*dirs)[n].fileName + (*dirs)[n].bytes
-		}
-		offset := ""
-		for i := 0; i < v.offsetPrintable; i++ {
-			offset += off
-		}
-		for i := 0; i < v.offsetTab; i++ {
-			offset += "\t"
-		}
-		if _, err := fmt.Fprint(v.out, offset); err != nil {
-			return err
-		}
-		if _, err := fmt.Fprintln(v.out, printPath); err != nil {
-			return err
-		}
-		if (*dirs)[n].isDir == true {
-			offPrint, offTabs := v.offsetPrintable, v.offsetTab
-			if n == l {
-				offTabs++
-			} else {
-				offPrint++
-			}
-			vars := &mainVars{
-				out:             v.out,
-				printFiles:      v.printFiles,
-				offsetPrintable: offPrint,
-				offsetTab:       offTabs,
-			}
-			if err := dirTreeRun(absPath+"/"+(*dirs)[n].fileName, vars); err != nil {
-				return err
-			}
-		}
+// counter adds the entries to the report and to the failures on their way to the visitor
+type counter struct {
+	walk.Visitor
+	report   *treeReport // nil when the report is off
+	failures *int
+}
+
+func (c *counter) Root(e walk.Entry) error {
+	if c.report != nil {
+		c.report.size = e.Size
 	}
 	return nil
 }
 
-func getSortedDir(files []os.FileInfo, printFiles bool, abs string) *[]fileDir {
-	if len(files) == 0 {
-		return nil
-	}
-	var myDir = make([]fileDir, 1)
-
-	for f := range files {
-		fileName := files[f].Name()
-		isDir := files[f].IsDir()
-		if isDir == false && printFiles == true {
-			var value string
-			if files[f].Size() == 0 {
-				value = " (empty)"
-			} else {
-				value = " (" + strconv.FormatInt(files[f].Size(), 10) + "b" + ")"
-			}
-			myDir = append(myDir, fileDir{fileName: fileName, bytes: value})
-		} else if isDir == true {
-			myDir = append(myDir, fileDir{fileName: fileName, isDir: true})
-		}
+func (c *counter) count(e walk.Entry) {
+	if e.Err != nil {
+		*c.failures++
+	}
+	if c.report == nil {
+		return
+	}
+	if e.IsDir {
+		c.report.dirs++
+	} else {
+		c.report.files++
 	}
+	switch e.Status {
+	case walk.Added:
+		c.report.added++
+	case walk.Removed:
+		c.report.removed++
+	case walk.Changed:
+		c.report.changed++
+	}
+}
 
-	sort.SliceStable(myDir, func(i, j int) bool {
-		return myDir[i].fileName < myDir[j].fileName
-	})
-	myDir[0] = fileDir{fileName: abs}
+func (c *counter) EnterDir(e walk.Entry) error {
+	c.count(e)
+	return c.Visitor.EnterDir(e)
+}
 
-	return &myDir
+func (c *counter) File(e walk.Entry) error {
+	c.count(e)
+	return c.Visitor.File(e)
 }
 
-func dirTreeRun(path string, vars *mainVars) error {
-	file, err := os.Open(path)
-	defer file.Close()
+// runTree prints the whole tree of the dir or the archive in vars.format
+func runTree(path string, vars *mainVars) error {
+	if vars.failures == nil {
+		vars.failures = new(int)
+	}
+	if vars.diffWith != "" {
+		return runDiff(path, vars.diffWith, vars)
+	}
+	if vars.watch {
+		return watchTree(path, vars, nil)
+	}
+	fsys, closer, err := walk.Open(path)
 	if err != nil {
-		return fmt.Errorf("can't open dir: %w", err)
+		return err
 	}
+	defer closer.Close()
+	printer := newPrinter(vars)
+	vars.visitor = printer
+	return printRoot(path, fsys, printer, vars)
+}
 
-	files, err := file.Readdir(0)
-	if err != nil {
-		fmt.Errorf("error during reading dir: %w", err)
+// printRoot prints the tree of fsys, root is the name of its top.
+// The entries go to vars.visitor, the printer itself or a visitor wrapping it.
+func printRoot(root string, fsys fs.FS, printer treePrinter, vars *mainVars) error {
+	if err := printer.begin(root); err != nil {
+		return err
+	}
+	counted := &counter{Visitor: vars.visitor, report: vars.report, failures: vars.failures}
+	if err := walk.WalkFS(fsys, vars.opts, counted); err != nil {
+		return err
 	}
-	if len(files) == 0 {
-		return nil
+	if err := printer.end(vars.report); err != nil {
+		return err
 	}
+	return vars.partial()
+}
 
-	currentDir := getSortedDir(files, vars.printFiles, path)
-	return printTree(currentDir, vars)
+// runDiff prints the merged tree of pathA and pathB
+func runDiff(pathA, pathB string, vars *mainVars) error {
+	printer := newPrinter(vars)
+	if err := printer.begin(pathA + " -> " + pathB); err != nil {
+		return err
+	}
+	counted := &counter{Visitor: printer, report: vars.report, failures: vars.failures}
+	if err := walk.Diff(pathA, pathB, vars.opts, counted); err != nil {
+		return err
+	}
+	if err := printer.end(vars.report); err != nil {
+		return err
+	}
+	return vars.partial()
+}
+
+// partial tells that the tree is printed but some entries could not be read
+func (v *mainVars) partial() error {
+	if v.failures != nil && *v.failures > 0 {
+		return fmt.Errorf("%d entries could not be read", *v.failures)
+	}
+	return nil
 }
 
 func dirTree(out io.Writer, path string, printFiles bool) error {
-	vars := &mainVars{out: out, printFiles: printFiles}
-	return dirTreeRun(path, vars)
+	vars := &mainVars{out: out, opts: walk.Options{Files: printFiles}}
+	return runTree(path, vars)
+}
+
+// patterns is a flag of the globs separated by | and repeated
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, "|")
+}
+
+func (p *patterns) Set(value string) error {
+	*p = append(*p, strings.Split(value, "|")...)
+	return nil
+}
+
+func parseArgs(args []string) (string, *mainVars, error) {
+	var opts walk.Options
+	var cols columns
+	var baseURL string
+	var watch bool
+	fs := flag.NewFlagSet("tree", flag.ContinueOnError)
+	fs.BoolVar(&opts.Files, "f", false, "print files with their sizes")
+	fs.IntVar(&opts.MaxDepth, "L", 0, "descend only `level` directories deep")
+	fs.Var((*patterns)(&opts.Exclude), "I", "do not list entries matching the `pattern`")
+	fs.Var((*patterns)(&opts.Include), "P", "list only files matching the `pattern`")
+	fs.Var((*patterns)(&opts.Match), "match", "list only the entries matching the `pattern` and the directories leading to them")
+	color := fs.Bool("C", false, "highlight the --match entries with colors")
+	fs.BoolVar(&opts.Gitignore, "gitignore", false, "filter entries by .gitignore files")
+	asJSON := fs.Bool("J", false, "print the tree as JSON")
+	asXML := fs.Bool("X", false, "print the tree as XML")
+	fs.StringVar(&baseURL, "H", "", "print the tree as an HTML page with the files linked under `baseURL`")
+	asMarkdown := fs.Bool("md", false, "print the tree as a Markdown list")
+	fs.BoolVar(&opts.DiskUsage, "du", false, "print the size of every directory as the sum of the files below it")
+	human := fs.Bool("h", false, "print sizes in human readable form (1.2K, 3.4M)")
+	fs.BoolVar(&opts.FollowLinks, "l", false, "follow symbolic links to directories")
+	fs.IntVar(&opts.Parallel, "parallel", 0, "read up to `n` directories at once")
+	fs.StringVar(&opts.Sort, "sort", "name", "sort entries by name, size, mtime or version")
+	fs.BoolVar(&opts.Reverse, "r", false, "reverse the sort order")
+	fs.BoolVar(&opts.DirsFirst, "dirsfirst", false, "list directories before files")
+	diff := fs.Bool("diff", false, "compare two trees: tree --diff dirA dirB")
+	fs.BoolVar(&opts.Checksum, "checksum", false, "compare the contents of the files of the same size in --diff")
+	fs.BoolVar(&cols.perms, "p", false, "print the permissions column")
+	fs.BoolVar(&cols.user, "u", false, "print the owner column, padded to 8 letters")
+	fs.BoolVar(&cols.group, "g", false, "print the group column, padded to 8 letters")
+	fs.BoolVar(&cols.date, "D", false, "print the modification time column")
+	fs.StringVar(&opts.Hash, "hash", "", "print the digest of the files with `algorithm` md5, sha1, sha256 or sha512")
+	fs.BoolVar(&opts.Strict, "strict", false, "stop at the first unreadable directory or file instead of printing the error in the tree")
+	fs.BoolVar(&watch, "watch", false, "keep running and print the tree again when it changes")
+	noReport := fs.Bool("noreport", false, "do not print the directories and files count at the end")
+
+	// flags are allowed both before and after the path
+	var paths []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return "", nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		paths = append(paths, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	var diffWith string
+	if *diff {
+		if len(paths) != 2 {
+			return "", nil, fmt.Errorf("usage go run . --diff dirA dirB [flags], see -help for the flags")
+		}
+		// the trees are merged by the ascending names
+		if opts.Sort != "name" || opts.Reverse || opts.DirsFirst {
+			return "", nil, fmt.Errorf("--diff works only with the name order")
+		}
+		diffWith = paths[1]
+	} else if len(paths) != 1 {
+		return "", nil, fmt.Errorf("usage go run . path [-f] [flags], see -help for the flags")
+	}
+	if watch && diffWith != "" {
+		return "", nil, fmt.Errorf("--watch can't be used with --diff")
+	}
+	asHTML := false
+	fs.Visit(func(f *flag.Flag) {
+		asHTML = asHTML || f.Name == "H"
+	})
+	formats := 0
+	for _, on := range []bool{*asJSON, *asXML, asHTML, *asMarkdown} {
+		if on {
+			formats++
+		}
+	}
+	if formats > 1 {
+		return "", nil, fmt.Errorf("-J, -X, -H and --md can't be used together")
+	}
+
+	if len(opts.Match) > 0 {
+		opts.Files = true // the files are what is searched for most of the time
+	}
+	opts.Owners = cols.user || cols.group
+	if err := opts.Validate(); err != nil {
+		return "", nil, err
+	}
+	cols.hash = opts.Hash
+	vars := &mainVars{opts: opts, columns: cols, failures: new(int)}
+	vars.human, vars.baseURL, vars.diffWith = *human, baseURL, diffWith
+	vars.color, vars.watch = *color, watch
+	if !*noReport {
+		vars.report = &treeReport{}
+	}
+	if *asJSON {
+		vars.format = "json"
+	} else if *asXML {
+		vars.format = "xml"
+	} else if asHTML {
+		vars.format = "html"
+	} else if *asMarkdown {
+		vars.format = "md"
+	}
+	return paths[0], vars, nil
 }
 
 func main() {
-	out := os.Stdout
-	if !(len(os.Args) == 2 || len(os.Args) == 3) {
-		panic("usage go run main.go . [-f]")
+	path, vars, err := parseArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	path := os.Args[1]
-	printFiles := len(os.Args) == 3 && os.Args[2] == "-f"
-	err := dirTree(out, path, printFiles)
 	if err != nil {
-		panic(err.Error())
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	vars.out = os.Stdout
+	if err := runTree(path, vars); err != nil {
+		fmt.Fprintln(os.Stderr, "tree:", err)
+		os.Exit(1)
 	}
 }
@@ -1,8 +1,25 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"hw1_tree_cmd/walk"
 )
 
 const testFullResult = `├───project
@@ -73,3 +90,803 @@ func TestTreeDir(t *testing.T) {
 		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDirResult)
 	}
 }
+
+const testFilterResult = `├───project
+│	└───gopher.png (70372b)
+├───static
+│	├───a_lorem
+│	│	├───gopher.png (70372b)
+│	│	└───ipsum
+│	├───html
+│	├───js
+│	└───z_lorem
+│		├───gopher.png (70372b)
+│		└───ipsum
+└───zline
+	└───lorem
+		├───gopher.png (70372b)
+		└───ipsum
+
+11 directories, 4 files
+`
+
+func TestTreeFilter(t *testing.T) {
+	out := new(bytes.Buffer)
+	path, vars, err := parseArgs([]string{"testdata", "-f", "-L", "3", "-P", "*.png", "-I", "css|*.txt"})
+	if err != nil {
+		t.Fatalf("can't parse args: %v", err)
+	}
+	vars.out = out
+	if err := runTree(path, vars); err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testFilterResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testFilterResult)
+	}
+}
+
+const testGitignoreResult = `├───.gitignore (21b)
+├───keep
+│	├───.gitignore (11b)
+│	├───a.log (empty)
+│	├───main.go (empty)
+│	├───sub
+│	│	└───y.go (empty)
+│	└───vendor
+│		└───lib.go (empty)
+└───main.go (empty)
+`
+
+const testDuResult = `├───project (69K)
+│	├───file.txt (19b)
+│	└───gopher.png (69K)
+├───static (275K)
+│	├───a_lorem (137K)
+│	├───css (28b)
+│	├───empty.txt (empty)
+│	├───html (57b)
+│	├───js (10b)
+│	└───z_lorem (137K)
+├───zline (137K)
+│	├───empty.txt (empty)
+│	└───lorem (137K)
+└───zzfile.txt (empty)
+
+481K used in 9 directories, 5 files
+`
+
+func TestTreeDu(t *testing.T) {
+	out := new(bytes.Buffer)
+	path, vars, err := parseArgs([]string{"--du", "-h", "-L", "2", "testdata", "-f"})
+	if err != nil {
+		t.Fatalf("can't parse args: %v", err)
+	}
+	vars.out = out
+	if err := runTree(path, vars); err != nil {
+		t.Errorf("test for OK Failed - error")
+	}
+	result := out.String()
+	if result != testDuResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDuResult)
+	}
+}
+
+func makeTree(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestTreeGitignore(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		".gitignore":         "*.log\nbuild/\n/vendor\n",
+		"main.go":            "",
+		"debug.log":          "",
+		"build/out.bin":      "",
+		"vendor/lib/lib.go":  "",
+		"keep/.gitignore":    "!a.log\n*.o\n",
+		"keep/a.log":         "",
+		"keep/b.o":           "",
+		"keep/main.go":       "",
+		"keep/vendor/lib.go": "",
+		"keep/sub/x.o":       "",
+		"keep/sub/y.go":      "",
+	})
+
+	// the dirs read ahead keep the rules of the .gitignore files above them
+	for _, workers := range []int{0, 2} {
+		out := new(bytes.Buffer)
+		vars := &mainVars{out: out, opts: walk.Options{Files: true, Gitignore: true, Parallel: workers}}
+		if err := runTree(root, vars); err != nil {
+			t.Errorf("test for OK Failed - error: %v", err)
+		}
+		result := out.String()
+		if result != testGitignoreResult {
+			t.Errorf("test for %d workers Failed - results not match\nGot:\n%v\nExpected:\n%v", workers, result, testGitignoreResult)
+		}
+	}
+}
+
+const testTextResult = `├───a "b".txt (3b)
+└───dir
+	├───sub
+	│	└───x (empty)
+	└───y (empty)
+`
+
+const testJSONResult = `[
+  {"type":"directory","name":"ROOT","contents":[
+    {"type":"file","name":"a \"b\".txt","size":3,"mode":"-rw-r--r--"},
+    {"type":"directory","name":"dir","mode":"drwxr-xr-x","contents":[
+      {"type":"directory","name":"sub","mode":"drwxr-xr-x","contents":[
+        {"type":"file","name":"x","size":0,"mode":"-rw-r--r--"}
+      ]},
+      {"type":"file","name":"y","size":0,"mode":"-rw-r--r--"}
+    ]}
+  ]}
+]
+`
+
+const testXMLResult = `<?xml version="1.0" encoding="UTF-8"?>
+<tree>
+  <directory name="ROOT">
+    <file name="a &#34;b&#34;.txt" size="3" mode="-rw-r--r--"></file>
+    <directory name="dir" mode="drwxr-xr-x">
+      <directory name="sub" mode="drwxr-xr-x">
+        <file name="x" size="0" mode="-rw-r--r--"></file>
+      </directory>
+      <file name="y" size="0" mode="-rw-r--r--"></file>
+    </directory>
+  </directory>
+</tree>
+`
+
+const testHTMLResult = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ROOT</title>
+<style>
+body { font-family: monospace; }
+ul { list-style: none; padding-left: 1.5em; }
+summary { cursor: pointer; }
+.added { color: green; }
+.removed { color: red; text-decoration: line-through; }
+.changed { color: darkorange; }
+</style>
+</head>
+<body>
+<details open><summary>ROOT</summary>
+<ul>
+  <li><a href="http://wiki/files/a%20%22b%22.txt">a &#34;b&#34;.txt</a> (3b)</li>
+  <li><details><summary>dir</summary>
+  <ul>
+    <li><details><summary>sub</summary>
+    <ul>
+      <li><a href="http://wiki/files/dir/sub/x">x</a> (empty)</li>
+    </ul>
+    </details></li>
+    <li><a href="http://wiki/files/dir/y">y</a> (empty)</li>
+  </ul>
+  </details></li>
+</ul>
+</details>
+</body>
+</html>
+`
+
+const testMarkdownResult = `- **ROOT**
+  - a "b".txt (3b)
+  - **dir/**
+    - **sub/**
+      - x (empty)
+    - y (empty)
+`
+
+func TestTreeFormats(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		`a "b".txt`: "abc",
+		"dir/sub/x": "",
+		"dir/y":     "",
+	})
+	for _, dir := range []string{"dir", "dir/sub"} {
+		if err := os.Chmod(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for format, expected := range map[string]string{
+		"":     testTextResult,
+		"json": testJSONResult,
+		"xml":  testXMLResult,
+		"html": testHTMLResult,
+		"md":   testMarkdownResult,
+	} {
+		out := new(bytes.Buffer)
+		vars := &mainVars{out: out, opts: walk.Options{Files: true}, format: format, baseURL: "http://wiki/files/"}
+		if err := runTree(root, vars); err != nil {
+			t.Errorf("test for %s Failed - error: %v", format, err)
+		}
+		result := strings.ReplaceAll(out.String(), root, "ROOT")
+		if result != expected {
+			t.Errorf("test for %s Failed - results not match\nGot:\n%v\nExpected:\n%v", format, result, expected)
+		}
+	}
+}
+
+func TestTreeSort(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"file1":     "a",
+		"file10":    "abc",
+		"file2":     "ab",
+		"b_dir/x":   "abcd",
+		"a_dir/v01": "",
+		"a_dir/v1a": "",
+		"a_dir/v2":  "",
+	})
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--sort=version"}, "├───a_dir\n│\t├───v01 (empty)\n│\t├───v1a (empty)\n│\t└───v2 (empty)\n├───b_dir\n│\t└───x (4b)\n├───file1 (1b)\n├───file2 (2b)\n└───file10 (3b)\n"},
+		{[]string{"--sort=version", "-r", "--dirsfirst", "-L", "1"}, "├───b_dir\n├───a_dir\n├───file10 (3b)\n├───file2 (2b)\n└───file1 (1b)\n"},
+		{[]string{"--sort=size", "--du", "-L", "1"}, "├───b_dir (4b)\n├───file10 (3b)\n├───file2 (2b)\n├───file1 (1b)\n└───a_dir (empty)\n"},
+	}
+	for _, c := range cases {
+		out := new(bytes.Buffer)
+		path, vars, err := parseArgs(append(c.args, root, "-f", "--noreport"))
+		if err != nil {
+			t.Fatalf("can't parse args %v: %v", c.args, err)
+		}
+		vars.out = out
+		if err := runTree(path, vars); err != nil {
+			t.Errorf("test for %v Failed - error: %v", c.args, err)
+		}
+		if result := out.String(); result != c.expected {
+			t.Errorf("test for %v Failed - results not match\nGot:\n%v\nExpected:\n%v", c.args, result, c.expected)
+		}
+	}
+}
+
+const testLinksResult = `├───a
+│	├───b
+│	│	└───up -> ..
+│	└───f (3b)
+└───alink -> a
+`
+
+const testLinksFollowedResult = `├───a
+│	├───b
+│	│	└───up -> .. [recursive, not followed]
+│	└───f (3b)
+└───alink -> a
+	├───b
+	│	└───up -> .. [recursive, not followed]
+	└───f (3b)
+`
+
+func TestTreeSymlinks(t *testing.T) {
+	root := makeTree(t, map[string]string{"a/f": "abc", "a/b/.keep": ""})
+	links := map[string]string{"a/b/up": "..", "alink": "a"}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	// the archives resolve the symlinks they keep like the disk does
+	dir := t.TempDir()
+	for _, tree := range []string{root, archiveTree(t, root, filepath.Join(dir, "links.tgz")), archiveTree(t, root, filepath.Join(dir, "links.zip"))} {
+		for followLinks, expected := range map[bool]string{false: testLinksResult, true: testLinksFollowedResult} {
+			out := new(bytes.Buffer)
+			vars := &mainVars{out: out, opts: walk.Options{Files: true, FollowLinks: followLinks, Exclude: []string{".keep"}}}
+			if err := runTree(tree, vars); err != nil {
+				t.Errorf("test for %s -l=%v Failed - error: %v", filepath.Base(tree), followLinks, err)
+			}
+			if result := out.String(); result != expected {
+				t.Errorf("test for %s -l=%v Failed - results not match\nGot:\n%v\nExpected:\n%v", filepath.Base(tree), followLinks, result, expected)
+			}
+		}
+	}
+}
+
+func TestTreeParallel(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		for printFiles, expected := range map[bool]string{true: testFullResult, false: testDirResult} {
+			out := new(bytes.Buffer)
+			vars := &mainVars{out: out, opts: walk.Options{Files: printFiles, Parallel: workers}}
+			if err := runTree("testdata", vars); err != nil {
+				t.Errorf("test for %d workers Failed - error: %v", workers, err)
+			}
+			if result := out.String(); result != expected {
+				t.Errorf("test for %d workers Failed - results not match\nGot:\n%v\nExpected:\n%v", workers, result, expected)
+			}
+		}
+
+		out := new(bytes.Buffer)
+		path, vars, err := parseArgs([]string{"--du", "-h", "-L", "2", "testdata", "-f", "--parallel", strconv.Itoa(workers)})
+		if err != nil {
+			t.Fatalf("can't parse args: %v", err)
+		}
+		vars.out = out
+		if err := runTree(path, vars); err != nil {
+			t.Errorf("test for %d workers Failed - error: %v", workers, err)
+		}
+		if result := out.String(); result != testDuResult {
+			t.Errorf("test for %d workers Failed - results not match\nGot:\n%v\nExpected:\n%v", workers, result, testDuResult)
+		}
+	}
+}
+
+// archiveTestdata packs testdata into dir/testdata.<ext>
+func archiveTestdata(t *testing.T, dir, ext string) string {
+	return archiveTree(t, "testdata", filepath.Join(dir, "testdata"+ext))
+}
+
+// archiveTree packs the tree at root into name, the symlinks are kept
+func archiveTree(t *testing.T, root, name string) string {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var add func(path string, info fs.FileInfo, data []byte) error
+	var closers []io.Closer
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		zw := zip.NewWriter(f)
+		closers = append(closers, zw)
+		add = func(path string, info fs.FileInfo, data []byte) error {
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			hdr.Name = path
+			if info.IsDir() {
+				hdr.Name += "/"
+			}
+			w, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		}
+	default:
+		var w io.Writer = f
+		if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+			zw := gzip.NewWriter(f)
+			closers = append(closers, zw)
+			w = zw
+		}
+		tw := tar.NewWriter(w)
+		closers = append([]io.Closer{tw}, closers...)
+		add = func(path string, info fs.FileInfo, data []byte) error {
+			var link string
+			if info.Mode()&fs.ModeSymlink != 0 {
+				link, data = string(data), nil
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = "./" + path
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err = tw.Write(data)
+			return err
+		}
+	}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var data []byte
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			data = []byte(link)
+		case !d.IsDir():
+			if data, err = os.ReadFile(path); err != nil {
+				return err
+			}
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return add(filepath.ToSlash(rel), info, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return name
+}
+
+func TestTreeArchive(t *testing.T) {
+	dir := t.TempDir()
+	for _, ext := range []string{".tar", ".tar.gz", ".zip"} {
+		archive := archiveTestdata(t, dir, ext)
+		for printFiles, expected := range map[bool]string{true: testFullResult, false: testDirResult} {
+			out := new(bytes.Buffer)
+			if err := dirTree(out, archive, printFiles); err != nil {
+				t.Errorf("test for %s Failed - error: %v", ext, err)
+			}
+			if result := out.String(); result != expected {
+				t.Errorf("test for %s Failed - results not match\nGot:\n%v\nExpected:\n%v", ext, result, expected)
+			}
+		}
+	}
+}
+
+const testNoDirsResult = `└───a
+	├───b
+	│	└───f (3b)
+	└───x -> b
+		└───f (3b)
+`
+
+// the archives made without the headers of the dirs get their dirs from the paths of the files
+func TestTreeArchiveNoDirs(t *testing.T) {
+	dir := t.TempDir()
+	tarName, zipName := filepath.Join(dir, "nodirs.tar"), filepath.Join(dir, "nodirs.zip")
+	tf, err := os.Create(tarName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(tf)
+	tw.WriteHeader(&tar.Header{Name: "a/b/f", Mode: 0644, Size: 3})
+	tw.Write([]byte("abc"))
+	tw.WriteHeader(&tar.Header{Name: "a/x", Typeflag: tar.TypeSymlink, Linkname: "b", Mode: 0777})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tf.Close()
+
+	zf, err := os.Create(zipName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(zf)
+	w, _ := zw.Create("a/b/f")
+	w.Write([]byte("abc"))
+	hdr := &zip.FileHeader{Name: "a/x"}
+	hdr.SetMode(fs.ModeSymlink | 0777)
+	w, _ = zw.CreateHeader(hdr)
+	w.Write([]byte("b"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zf.Close()
+
+	for _, archive := range []string{tarName, zipName} {
+		out := new(bytes.Buffer)
+		vars := &mainVars{out: out, opts: walk.Options{Files: true, FollowLinks: true}}
+		if err := runTree(archive, vars); err != nil {
+			t.Errorf("test for %s Failed - error: %v", filepath.Base(archive), err)
+		}
+		if result := out.String(); result != testNoDirsResult {
+			t.Errorf("test for %s Failed - results not match\nGot:\n%v\nExpected:\n%v", filepath.Base(archive), result, testNoDirsResult)
+		}
+	}
+}
+
+const testDiffResult = `  ├───keep
+  │	├───same.txt (1b)
+~ │	└───size.txt (3b)
++ ├───new
++ │	└───g (empty)
+- ├───old
+- │	└───f (empty)
+  ├───same_size.txt (3b)
+- ├───type
++ └───type (1b)
+
+4 directories, 6 files; 3 added, 3 removed, 1 changed
+`
+
+func TestTreeDiff(t *testing.T) {
+	a := makeTree(t, map[string]string{
+		"keep/same.txt": "x",
+		"keep/size.txt": "ab",
+		"same_size.txt": "abc",
+		"old/f":         "",
+		"type/x":        "",
+	})
+	b := makeTree(t, map[string]string{
+		"keep/same.txt": "x",
+		"keep/size.txt": "abc",
+		"same_size.txt": "abd",
+		"new/g":         "",
+		"type":          "x",
+	})
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, root := range []string{a, b} {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err == nil {
+				err = os.Chtimes(path, mtime, mtime)
+			}
+			return err
+		})
+	}
+
+	for _, checksum := range []bool{false, true} {
+		expected := testDiffResult
+		if checksum {
+			expected = strings.Replace(expected, "  ├───same_size", "~ ├───same_size", 1)
+			expected = strings.Replace(expected, "1 changed", "2 changed", 1)
+		}
+		out := new(bytes.Buffer)
+		args := []string{"--diff", a, b, "-f", "-I", "x"}
+		if checksum {
+			args = append(args, "--checksum")
+		}
+		path, vars, err := parseArgs(args)
+		if err != nil {
+			t.Fatalf("can't parse args: %v", err)
+		}
+		vars.out = out
+		if err := runTree(path, vars); err != nil {
+			t.Errorf("test for --checksum=%v Failed - error: %v", checksum, err)
+		}
+		if result := out.String(); result != expected {
+			t.Errorf("test for --checksum=%v Failed - results not match\nGot:\n%v\nExpected:\n%v", checksum, result, expected)
+		}
+	}
+
+	for _, args := range [][]string{{a, b, "-r"}, {a, b, "--dirsfirst"}, {a, b, "--sort", "size"}, {a}, {a, b, a}} {
+		if _, _, err := parseArgs(append([]string{"--diff"}, args...)); err == nil {
+			t.Errorf("test for --diff %v Failed - expected an error", args)
+		}
+	}
+}
+
+func TestTreeColumns(t *testing.T) {
+	root := makeTree(t, map[string]string{"a.txt": "abc", "dir/b": ""})
+	mtime := time.Date(2020, 1, 1, 12, 30, 0, 0, time.UTC)
+	// the sticky and the setgid bits do not widen the mode column
+	for name, mode := range map[string]os.FileMode{"a.txt": 0644, "dir": 0755 | os.ModeSticky, "dir/b": 0600 | os.ModeSetgid} {
+		if err := os.Chmod(filepath.Join(root, name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	me, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+
+	owner, date := pad(me.Username, 8), mtime.Local().Format(dateLayout)
+	expected := "-rw-r--r-- " + owner + " " + date + " a9993e364706816aba3e25717850c26c9cd0d89d  ├───a.txt (3b)\n" +
+		"drwxr-xr-t " + owner + " " + date + " " + strings.Repeat(" ", 40) + "  └───dir\n" +
+		"-rw---S--- " + owner + " " + date + " da39a3ee5e6b4b0d3255bfef95601890afd80709  \t└───b (empty)\n"
+
+	out := new(bytes.Buffer)
+	path, vars, err := parseArgs([]string{root, "-f", "-p", "-u", "-D", "--hash=sha1", "--noreport"})
+	if err != nil {
+		t.Fatalf("can't parse args: %v", err)
+	}
+	vars.out = out
+	if err := runTree(path, vars); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != expected {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+// deniedFS fails to open the dirs of denied like a dir without the read permission
+type deniedFS struct {
+	fs.FS
+	denied map[string]bool
+}
+
+func (d deniedFS) Open(name string) (fs.File, error) {
+	if d.denied[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return d.FS.Open(name)
+}
+
+func TestTreeErrors(t *testing.T) {
+	fsys := deniedFS{fstest.MapFS{
+		"a/file":        {Data: []byte("abc")},
+		"locked/secret": {Data: []byte("x")},
+		"z/file":        {Data: []byte("")},
+	}, map[string]bool{"locked": true}}
+
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-f"}, "├───a\n" +
+			"│\t└───file (3b)\n" +
+			"├───locked [error opening dir: permission denied]\n" +
+			"└───z\n" +
+			"\t└───file (empty)\n" +
+			"\n3 directories, 2 files\n"},
+		{[]string{"--du", "--parallel", "2", "--noreport"}, "├───a (3b)\n" +
+			"├───locked [error opening dir: permission denied]\n" +
+			"└───z (empty)\n"},
+		{[]string{"-J", "--noreport"}, `[
+  {"type":"directory","name":"root","contents":[
+    {"type":"directory","name":"a","mode":"dr-xr-xr-x","contents":[
+    ]},
+    {"type":"directory","name":"locked","error":"error opening dir: permission denied","mode":"dr-xr-xr-x","contents":[
+    ]},
+    {"type":"directory","name":"z","mode":"dr-xr-xr-x","contents":[
+    ]}
+  ]}
+]
+`},
+	}
+	for _, c := range cases {
+		out := new(bytes.Buffer)
+		_, vars, err := parseArgs(append([]string{"root"}, c.args...))
+		if err != nil {
+			t.Fatalf("can't parse args %v: %v", c.args, err)
+		}
+		vars.out = out
+		printer := newPrinter(vars)
+		vars.visitor = printer
+		if err := printRoot("root", fsys, printer, vars); err == nil || err.Error() != "1 entries could not be read" {
+			t.Errorf("test for %v Failed - expected the partial error, got %v", c.args, err)
+		}
+		if result := out.String(); result != c.expected {
+			t.Errorf("test for %v Failed - results not match\nGot:\n%v\nExpected:\n%v", c.args, result, c.expected)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	_, vars, err := parseArgs([]string{"root", "--strict"})
+	if err != nil {
+		t.Fatalf("can't parse args: %v", err)
+	}
+	vars.out = out
+	printer := newPrinter(vars)
+	vars.visitor = printer
+	if err := printRoot("root", fsys, printer, vars); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("test for --strict Failed - expected permission denied, got %v", err)
+	}
+	if result, expected := out.String(), "├───a\n"; result != expected {
+		t.Errorf("test for --strict Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestTreeMatch(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"api/v1/user.proto": "ab",
+		"api/v1/user.go":    "",
+		"api/README":        "",
+		"deep/a/b/c.proto":  "",
+		"empty/x.go":        "",
+		"top.proto":         "",
+	})
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--match", "*.proto"}, "├───api\n" +
+			"│\t└───v1\n" +
+			"│\t\t└───user.proto (2b)\n" +
+			"├───deep\n" +
+			"│\t└───a\n" +
+			"│\t\t└───b\n" +
+			"│\t\t\t└───c.proto (empty)\n" +
+			"└───top.proto (empty)\n" +
+			"\n5 directories, 3 files\n"},
+		{[]string{"--match", "*.proto", "-L", "3", "-C", "--noreport"}, "├───api\n" +
+			"│\t└───v1\n" +
+			"│\t\t└───" + colorMatch + "user.proto" + colorReset + " (2b)\n" +
+			"└───" + colorMatch + "top.proto" + colorReset + " (empty)\n"},
+		{[]string{"--match", "v1|README", "--parallel", "2", "--noreport"}, "└───api\n" +
+			"\t├───README (empty)\n" +
+			"\t└───v1\n"},
+	}
+	for _, c := range cases {
+		out := new(bytes.Buffer)
+		path, vars, err := parseArgs(append([]string{root}, c.args...))
+		if err != nil {
+			t.Fatalf("can't parse args %v: %v", c.args, err)
+		}
+		vars.out = out
+		if err := runTree(path, vars); err != nil {
+			t.Errorf("test for %v Failed - error: %v", c.args, err)
+		}
+		if result := out.String(); result != c.expected {
+			t.Errorf("test for %v Failed - results not match\nGot:\n%v\nExpected:\n%v", c.args, result, c.expected)
+		}
+	}
+}
+
+// lockedBuffer is written by the watch while the test reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTreeWatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("--watch works only on linux")
+	}
+	root := makeTree(t, map[string]string{"a.txt": "abc", "dir/deep/x": ""})
+	path, vars, err := parseArgs([]string{root, "-f", "-L", "2", "--noreport", "--watch"})
+	if err != nil {
+		t.Fatalf("can't parse args: %v", err)
+	}
+	out := &lockedBuffer{}
+	vars.out = out
+	stop, done := make(chan struct{}), make(chan error)
+	go func() {
+		done <- watchTree(path, vars, stop)
+	}()
+
+	waitFor := func(expected string) {
+		t.Helper()
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if out.String() == expected {
+				return
+			}
+		}
+		t.Fatalf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+	first := "├───a.txt (3b)\n└───dir\n\t└───deep\n"
+	waitFor(first)
+
+	// deep is below -L, it is not watched
+	if err := os.WriteFile(filepath.Join(root, "dir/deep/y"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("abcd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := "├───a.txt (4b)\n└───dir\n\t└───deep\n"
+	waitFor(first + "\n" + second)
+
+	if err := os.WriteFile(filepath.Join(root, "dir/b"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(first + "\n" + second + "\n" + "├───a.txt (4b)\n└───dir\n\t├───b (empty)\n\t└───deep\n")
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	"hw1_tree_cmd/walk"
+)
+
+const htmlHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+body { font-family: monospace; }
+ul { list-style: none; padding-left: 1.5em; }
+summary { cursor: pointer; }
+.added { color: green; }
+.removed { color: red; text-decoration: line-through; }
+.changed { color: darkorange; }
+</style>
+</head>
+<body>
+<details open><summary>%[1]s</summary>
+<ul>
+`
+
+// htmlPrinter makes a page with a collapsible <details> for every directory and a link for every file
+type htmlPrinter struct {
+	text textPrinter // formats the sizes and the report like the text output
+	base string
+	path []string // names of the open dirs
+}
+
+func (p *htmlPrinter) link(name string) string {
+	var segments []string
+	for _, dir := range append(p.path, name) {
+		segments = append(segments, url.PathEscape(dir))
+	}
+	return p.base + "/" + strings.Join(segments, "/")
+}
+
+// label is the entry without the link, the columns go before the name like in the text output
+func (p *htmlPrinter) label(f walk.Entry, name string) string {
+	var column string
+	if p.text.columns.any() {
+		column = "<code>" + html.EscapeString(p.text.columns.format(f)) + "</code>"
+	}
+	return column + name + html.EscapeString(p.text.suffix(f))
+}
+
+func (p *htmlPrinter) item(f walk.Entry) string {
+	if f.Status == 0 {
+		return "<li>"
+	}
+	return `<li class="` + statusNames[f.Status] + `">`
+}
+
+func (p *htmlPrinter) begin(root string) error {
+	_, err := fmt.Fprintf(p.text.out, htmlHead, html.EscapeString(root))
+	return err
+}
+
+func (p *htmlPrinter) File(f walk.Entry) error {
+	name := `<a href="` + html.EscapeString(p.link(f.Name)) + `">` + html.EscapeString(f.Name) + "</a>"
+	_, err := fmt.Fprintf(p.text.out, "%s%s%s</li>\n", strings.Repeat("  ", f.Depth+1), p.item(f), p.label(f, name))
+	return err
+}
+
+func (p *htmlPrinter) EnterDir(f walk.Entry) error {
+	indent := strings.Repeat("  ", f.Depth+1)
+	p.path = append(p.path, f.Name)
+	_, err := fmt.Fprintf(p.text.out, "%s%s<details><summary>%s</summary>\n%s<ul>\n",
+		indent, p.item(f), p.label(f, html.EscapeString(f.Name)), indent)
+	return err
+}
+
+func (p *htmlPrinter) LeaveDir(f walk.Entry) error {
+	indent := strings.Repeat("  ", f.Depth+1)
+	p.path = p.path[:len(p.path)-1]
+	_, err := fmt.Fprintf(p.text.out, "%s</ul>\n%s</details></li>\n", indent, indent)
+	return err
+}
+
+func (p *htmlPrinter) end(report *treeReport) error {
+	var line string
+	if report != nil {
+		line = "<p>" + html.EscapeString(p.text.reportLine(report)) + "</p>\n"
+	}
+	_, err := fmt.Fprintf(p.text.out, "</ul>\n</details>\n%s</body>\n</html>\n", line)
+	return err
+}
+
+var mdEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, "#", `\#`)
+
+// mdPrinter makes a nested list, two spaces deeper for every level
+type mdPrinter struct {
+	text textPrinter
+}
+
+func (p *mdPrinter) line(f walk.Entry, name string) error {
+	var column string
+	if p.text.diff {
+		column = "`" + marker(f) + "` "
+	}
+	if p.text.columns.any() {
+		column += "`" + strings.TrimSpace(p.text.columns.format(f)) + "` "
+	}
+	_, err := fmt.Fprintf(p.text.out, "%s- %s%s%s\n", strings.Repeat("  ", f.Depth+1), column, name, mdEscaper.Replace(p.text.suffix(f)))
+	return err
+}
+
+func (p *mdPrinter) begin(root string) error {
+	_, err := fmt.Fprintf(p.text.out, "- **%s**\n", mdEscaper.Replace(root))
+	return err
+}
+
+func (p *mdPrinter) File(f walk.Entry) error {
+	return p.line(f, mdEscaper.Replace(f.Name))
+}
+
+func (p *mdPrinter) EnterDir(f walk.Entry) error {
+	return p.line(f, "**"+mdEscaper.Replace(f.Name)+"/**")
+}
+
+func (p *mdPrinter) LeaveDir(f walk.Entry) error {
+	return nil
+}
+
+func (p *mdPrinter) end(report *treeReport) error {
+	if report == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(p.text.out, "\n%s\n", p.text.reportLine(report))
+	return err
+}
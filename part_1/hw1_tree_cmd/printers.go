@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"hw1_tree_cmd/walk"
+)
+
+// treePrinter is a walk.Visitor writing the tree out, begin and end go around the walk
+type treePrinter interface {
+	walk.Visitor
+	begin(root string) error
+	end(report *treeReport) error
+}
+
+func newPrinter(v *mainVars) treePrinter {
+	switch v.format {
+	case "json":
+		return &jsonPrinter{out: v.out, du: v.opts.DiskUsage, diff: v.diffWith != "", columns: v.columns}
+	case "xml":
+		return &xmlPrinter{out: v.out, du: v.opts.DiskUsage, diff: v.diffWith != "", columns: v.columns}
+	}
+	text := textPrinter{out: v.out, du: v.opts.DiskUsage, human: v.human, printFiles: v.opts.Files, diff: v.diffWith != "", columns: v.columns, color: v.color}
+	switch v.format {
+	case "html":
+		return &htmlPrinter{text: text, base: strings.TrimSuffix(v.baseURL, "/")}
+	case "md":
+		return &mdPrinter{text: text}
+	}
+	return &text
+}
+
+// humanSize formats like 12b, 1.2K, 69K, 3.4M
+func humanSize(size int64) string {
+	if size < 1024 {
+		return strconv.FormatInt(size, 10) + "b"
+	}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len("KMGTPE") {
+		value /= 1024
+		unit++
+	}
+	if value < 10 {
+		return strconv.FormatFloat(value, 'f', 1, 64) + "KMGTPE"[unit-1:unit]
+	}
+	return strconv.FormatFloat(value, 'f', 0, 64) + "KMGTPE"[unit-1:unit]
+}
+
+type textPrinter struct {
+	out        io.Writer
+	du         bool
+	human      bool
+	printFiles bool
+	diff       bool
+	columns    columns
+	color      bool
+	prefix     []string
+}
+
+const (
+	colorMatch = "\x1b[1;31m"
+	colorReset = "\x1b[0m"
+)
+
+var statusNames = map[byte]string{walk.Added: "added", walk.Removed: "removed", walk.Changed: "changed"}
+
+// marker is the --diff column before the tree
+func marker(f walk.Entry) string {
+	if f.Status == 0 {
+		return "  "
+	}
+	return string(f.Status) + " "
+}
+
+func (p *textPrinter) formatSize(size int64) string {
+	if p.human {
+		return humanSize(size)
+	}
+	return strconv.FormatInt(size, 10) + "b"
+}
+
+func (p *textPrinter) suffix(f walk.Entry) string {
+	var suffix string
+	if f.Link != "" {
+		suffix = " -> " + f.Link
+	}
+	if f.Err != nil {
+		return suffix + " [" + errorText(f.Err) + "]"
+	}
+	if !f.IsDir || p.du {
+		if f.Size == 0 {
+			suffix += " (empty)"
+		} else {
+			suffix += " (" + p.formatSize(f.Size) + ")"
+		}
+	}
+	if f.Recursive {
+		suffix += " [recursive, not followed]"
+	}
+	return suffix
+}
+
+func (p *textPrinter) begin(root string) error {
+	return nil
+}
+
+func (p *textPrinter) File(f walk.Entry) error {
+	glyph := "├───"
+	if f.Last {
+		glyph = "└───"
+	}
+	var column string
+	if p.diff {
+		column = marker(f)
+	}
+	if p.columns.any() {
+		column += p.columns.format(f)
+	}
+	name := f.Name
+	if p.color && f.Matched {
+		name = colorMatch + name + colorReset
+	}
+	_, err := fmt.Fprintln(p.out, column+strings.Join(p.prefix, "")+glyph+name+p.suffix(f))
+	return err
+}
+
+func (p *textPrinter) EnterDir(f walk.Entry) error {
+	if err := p.File(f); err != nil {
+		return err
+	}
+	if f.Last {
+		p.prefix = append(p.prefix, "\t")
+	} else {
+		p.prefix = append(p.prefix, "│\t")
+	}
+	return nil
+}
+
+func (p *textPrinter) LeaveDir(f walk.Entry) error {
+	p.prefix = p.prefix[:len(p.prefix)-1]
+	return nil
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return strconv.Itoa(n) + " " + many
+}
+
+func (p *textPrinter) end(report *treeReport) error {
+	if report == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(p.out, "\n%s\n", p.reportLine(report))
+	return err
+}
+
+func (p *textPrinter) reportLine(report *treeReport) string {
+	line := plural(report.dirs, "directory", "directories")
+	if p.printFiles {
+		line += ", " + plural(report.files, "file", "files")
+	}
+	if p.du {
+		line = p.formatSize(report.size) + " used in " + line
+	}
+	if p.diff {
+		line += fmt.Sprintf("; %d added, %d removed, %d changed", report.added, report.removed, report.changed)
+	}
+	return line
+}
+
+type jsonPrinter struct {
+	out     io.Writer
+	du      bool
+	diff    bool
+	columns columns
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func entryType(f walk.Entry) string {
+	if f.Link != "" {
+		return "link"
+	}
+	if f.IsDir {
+		return "directory"
+	}
+	return "file"
+}
+
+func (p *jsonPrinter) attrs(f walk.Entry) string {
+	attrs := `{"type":"` + entryType(f) + `","name":` + jsonString(f.Name)
+	if f.Link != "" {
+		attrs += `,"target":` + jsonString(f.Link)
+	}
+	if f.Recursive {
+		attrs += `,"error":"recursive, not followed"`
+	}
+	if f.Err != nil {
+		attrs += `,"error":` + jsonString(errorText(f.Err))
+	}
+	if f.Status != 0 {
+		attrs += `,"status":"` + statusNames[f.Status] + `"`
+	}
+	if !f.IsDir || p.du {
+		attrs += `,"size":` + strconv.FormatInt(f.Size, 10)
+	}
+	attrs += `,"mode":` + jsonString(f.Mode.String())
+	if p.columns.user {
+		attrs += `,"user":` + jsonString(f.User)
+	}
+	if p.columns.group {
+		attrs += `,"group":` + jsonString(f.Group)
+	}
+	if p.columns.date {
+		attrs += `,"time":` + jsonString(f.ModTime.Format(dateLayout))
+	}
+	if f.Hash != "" {
+		attrs += `,"` + p.columns.hash + `":"` + f.Hash + `"`
+	}
+	return attrs
+}
+
+func (p *jsonPrinter) begin(root string) error {
+	_, err := fmt.Fprintf(p.out, "[\n  {\"type\":\"directory\",\"name\":%s,\"contents\":[\n", jsonString(root))
+	return err
+}
+
+func (p *jsonPrinter) File(f walk.Entry) error {
+	_, err := fmt.Fprintf(p.out, "%s%s}%s\n", strings.Repeat("  ", f.Depth+2), p.attrs(f), comma(f.Last))
+	return err
+}
+
+func (p *jsonPrinter) EnterDir(f walk.Entry) error {
+	_, err := fmt.Fprintf(p.out, "%s%s,\"contents\":[\n", strings.Repeat("  ", f.Depth+2), p.attrs(f))
+	return err
+}
+
+func (p *jsonPrinter) LeaveDir(f walk.Entry) error {
+	_, err := fmt.Fprintf(p.out, "%s]}%s\n", strings.Repeat("  ", f.Depth+2), comma(f.Last))
+	return err
+}
+
+func (p *jsonPrinter) end(report *treeReport) error {
+	if report == nil {
+		_, err := fmt.Fprint(p.out, "  ]}\n]\n")
+		return err
+	}
+	var size string
+	if p.du {
+		size = `,"size":` + strconv.FormatInt(report.size, 10)
+	}
+	var changes string
+	if p.diff {
+		changes = fmt.Sprintf(`,"added":%d,"removed":%d,"changed":%d`, report.added, report.removed, report.changed)
+	}
+	_, err := fmt.Fprintf(p.out, "  ]},\n  {\"type\":\"report\"%s,\"directories\":%d,\"files\":%d%s}\n]\n",
+		size, report.dirs, report.files, changes)
+	return err
+}
+
+func comma(last bool) string {
+	if last {
+		return ""
+	}
+	return ","
+}
+
+type xmlPrinter struct {
+	out     io.Writer
+	du      bool
+	diff    bool
+	columns columns
+}
+
+func xmlString(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (p *xmlPrinter) attrs(f walk.Entry) string {
+	attrs := ` name="` + xmlString(f.Name) + `"`
+	if f.Link != "" {
+		attrs += ` target="` + xmlString(f.Link) + `"`
+	}
+	if f.Recursive {
+		attrs += ` error="recursive, not followed"`
+	}
+	if f.Err != nil {
+		attrs += ` error="` + xmlString(errorText(f.Err)) + `"`
+	}
+	if f.Status != 0 {
+		attrs += ` status="` + statusNames[f.Status] + `"`
+	}
+	if !f.IsDir || p.du {
+		attrs += ` size="` + strconv.FormatInt(f.Size, 10) + `"`
+	}
+	attrs += ` mode="` + f.Mode.String() + `"`
+	if p.columns.user {
+		attrs += ` user="` + xmlString(f.User) + `"`
+	}
+	if p.columns.group {
+		attrs += ` group="` + xmlString(f.Group) + `"`
+	}
+	if p.columns.date {
+		attrs += ` time="` + f.ModTime.Format(dateLayout) + `"`
+	}
+	if f.Hash != "" {
+		attrs += ` ` + p.columns.hash + `="` + f.Hash + `"`
+	}
+	return attrs
+}
+
+func (p *xmlPrinter) begin(root string) error {
+	_, err := fmt.Fprintf(p.out, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<tree>\n  <directory name=\"%s\">\n", xmlString(root))
+	return err
+}
+
+func (p *xmlPrinter) File(f walk.Entry) error {
+	_, err := fmt.Fprintf(p.out, "%s<%s%s></%[2]s>\n", strings.Repeat("  ", f.Depth+2), entryType(f), p.attrs(f))
+	return err
+}
+
+func (p *xmlPrinter) EnterDir(f walk.Entry) error {
+	_, err := fmt.Fprintf(p.out, "%s<%s%s>\n", strings.Repeat("  ", f.Depth+2), entryType(f), p.attrs(f))
+	return err
+}
+
+func (p *xmlPrinter) LeaveDir(f walk.Entry) error {
+	_, err := fmt.Fprintf(p.out, "%s</%s>\n", strings.Repeat("  ", f.Depth+2), entryType(f))
+	return err
+}
+
+func (p *xmlPrinter) end(report *treeReport) error {
+	if report == nil {
+		_, err := fmt.Fprint(p.out, "  </directory>\n</tree>\n")
+		return err
+	}
+	var size string
+	if p.du {
+		size = "\n    <size>" + strconv.FormatInt(report.size, 10) + "</size>"
+	}
+	var changes string
+	if p.diff {
+		changes = fmt.Sprintf("\n    <added>%d</added>\n    <removed>%d</removed>\n    <changed>%d</changed>", report.added, report.removed, report.changed)
+	}
+	_, err := fmt.Fprintf(p.out, "  </directory>\n  <report>%s\n    <directories>%d</directories>\n    <files>%d</files>%s\n  </report>\n</tree>\n",
+		size, report.dirs, report.files, changes)
+	return err
+}
@@ -0,0 +1,441 @@
+package walk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fileID tells the same directory reached by different paths
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func getFileID(info fs.FileInfo) (fileID, bool) {
+	if id, ok := info.Sys().(fileID); ok {
+		return id, true
+	}
+	return sysFileID(info.Sys())
+}
+
+type noClose struct{}
+
+func (noClose) Close() error {
+	return nil
+}
+
+// Open opens the dir or the .tar, .tar.gz, .tgz, .zip archive at root for WalkFS
+func Open(root string) (fs.FS, io.Closer, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open dir: %w", err)
+	}
+	if info.IsDir() {
+		return os.DirFS(root), noClose{}, nil
+	}
+
+	name := strings.ToLower(root)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		tfs, err := openZip(root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't open archive: %w", err)
+		}
+		return tfs, tfs.zip, nil
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		tfs, err := openTar(root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't open archive: %w", err)
+		}
+		return tfs, noClose{}, nil
+	}
+	return nil, nil, fmt.Errorf("can't open dir: %w", &fs.PathError{Op: "open", Path: root, Err: errors.New("not a directory or an archive")})
+}
+
+type tarEntry struct {
+	id       fileID // given once, a header of the entry after its children keeps it
+	info     fs.FileInfo
+	link     string
+	children []string
+	zip      *zip.File // of a zip entry, its contents
+}
+
+// tarFS keeps only the headers of a tar or a zip, the contents are read from the archive again when opened
+type tarFS struct {
+	path    string
+	entries map[string]*tarEntry
+	zip     *zip.ReadCloser // of a zip, it is closed by the caller
+
+	mu      sync.Mutex
+	digests map[string]map[string]string // of the files of a tar by the algorithm and the path
+}
+
+type tarInfo struct {
+	fs.FileInfo
+	name  string
+	id    fileID
+	user  string
+	group string
+}
+
+func (i tarInfo) Name() string {
+	return i.name
+}
+
+func (i tarInfo) Sys() any {
+	return i.id
+}
+
+func tarReader(file string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(zr), f, nil
+	}
+	return tar.NewReader(r), f, nil
+}
+
+func cleanTarName(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+func openTar(file string) (*tarFS, error) {
+	tr, closer, err := tarReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	t := &tarFS{path: file, entries: map[string]*tarEntry{}}
+	t.add("", &tar.Header{Typeflag: tar.TypeDir, Mode: 0755})
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := cleanTarName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		t.add(name, hdr)
+	}
+	for _, e := range t.entries {
+		sort.Strings(e.children)
+	}
+	return t, nil
+}
+
+// openZip reads the headers of a zip like the ones of a tar, so the symlinks are resolved the same way
+func openZip(file string) (*tarFS, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tarFS{path: file, entries: map[string]*tarEntry{}, zip: zr}
+	t.add("", &tar.Header{Typeflag: tar.TypeDir, Mode: 0755})
+	for _, f := range zr.File {
+		name := cleanTarName(f.Name)
+		if name == "" {
+			continue
+		}
+		var link string
+		if f.Mode()&fs.ModeSymlink != 0 {
+			if link, err = readZipLink(f); err != nil {
+				zr.Close()
+				return nil, err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(f.FileInfo(), link)
+		if err != nil {
+			zr.Close()
+			return nil, err
+		}
+		t.add(name, hdr)
+		t.entries[name].zip = f
+	}
+	for _, e := range t.entries {
+		sort.Strings(e.children)
+	}
+	return t, nil
+}
+
+// readZipLink reads the target of a symlink, zip keeps it as the contents
+func readZipLink(f *zip.File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	target, err := io.ReadAll(r)
+	return string(target), err
+}
+
+func (t *tarFS) add(name string, hdr *tar.Header) {
+	e, exists := t.entries[name]
+	if !exists {
+		// the entries are never removed, so their count numbers them, the parents made below come after
+		e = &tarEntry{id: fileID{ino: uint64(len(t.entries) + 1)}}
+		t.entries[name] = e
+		if name != "" {
+			dir := path.Dir(name)
+			if dir == "." {
+				dir = ""
+			}
+			if _, ok := t.entries[dir]; !ok {
+				t.add(dir, &tar.Header{Typeflag: tar.TypeDir, Mode: 0755})
+			}
+			t.entries[dir].children = append(t.entries[dir].children, path.Base(name))
+		}
+	}
+	base := path.Base(name)
+	if name == "" {
+		base = "."
+	}
+	e.info = tarInfo{FileInfo: hdr.FileInfo(), name: base, id: e.id, user: hdr.Uname, group: hdr.Gname}
+	if hdr.Typeflag == tar.TypeSymlink {
+		e.link = hdr.Linkname
+	}
+}
+
+// lookup finds the entry of name, the symlinks on the way are resolved
+func (t *tarFS) lookup(op, name string, followLast bool) (string, *tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		name = ""
+	}
+	for hops := 0; hops < 40; hops++ {
+		cur := ""
+		parts := strings.Split(name, "/")
+		restarted := false
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			cur = path.Join(cur, part)
+			e, ok := t.entries[cur]
+			if !ok {
+				return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			last := i == len(parts)-1
+			if e.link == "" || (last && !followLast) {
+				if last {
+					return cur, e, nil
+				}
+				continue
+			}
+			target := path.Join(path.Dir(cur), e.link)
+			if path.IsAbs(e.link) {
+				target = cleanTarName(e.link)
+			}
+			if target == ".." || strings.HasPrefix(target, "../") {
+				return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			if target == "." {
+				target = ""
+			}
+			name = path.Join(append([]string{target}, parts[i+1:]...)...)
+			restarted = true
+			break
+		}
+		if !restarted {
+			return cur, t.entries[cur], nil
+		}
+	}
+	return "", nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many links")}
+}
+
+// digest is the hash of the file, all the files of the tar are hashed in one pass the first time,
+// opening them one by one scans the archive from the start for every file
+func (t *tarFS) digest(name, algorithm string) (string, error) {
+	full, _, err := t.lookup("open", name, true)
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	sums, ok := t.digests[algorithm]
+	if !ok {
+		if sums, err = t.hashAll(algorithm); err != nil {
+			t.mu.Unlock()
+			return "", err
+		}
+		if t.digests == nil {
+			t.digests = map[string]map[string]string{}
+		}
+		t.digests[algorithm] = sums
+	}
+	t.mu.Unlock()
+	if sum, ok := sums[full]; ok {
+		return sum, nil
+	}
+	// not a regular file, like a hard link, it is read like before
+	f, err := t.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readHash(f, algorithm)
+}
+
+func (t *tarFS) hashAll(algorithm string) (map[string]string, error) {
+	tr, closer, err := tarReader(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	sums := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return sums, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := cleanTarName(hdr.Name)
+		if _, seen := sums[name]; seen || !hdr.FileInfo().Mode().IsRegular() {
+			continue // tarFile reads the first one of the same name
+		}
+		if sums[name], err = readHash(tr, algorithm); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *tarFS) Open(name string) (fs.File, error) {
+	full, e, err := t.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if e.info.IsDir() {
+		return &tarDir{fs: t, path: full, entry: e}, nil
+	}
+	return &tarFile{fs: t, path: full, entry: e}, nil
+}
+
+func (t *tarFS) ReadLink(name string) (string, error) {
+	_, e, err := t.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.link == "" {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.link, nil
+}
+
+func (t *tarFS) Lstat(name string) (fs.FileInfo, error) {
+	_, e, err := t.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+type tarDir struct {
+	fs     *tarFS
+	path   string
+	entry  *tarEntry
+	offset int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) {
+	return d.entry.info, nil
+}
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+func (d *tarDir) Close() error {
+	return nil
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := d.entry.children[d.offset:]
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(names) {
+		names = names[:n]
+	}
+	d.offset += len(names)
+
+	entries := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fs.FileInfoToDirEntry(d.fs.entries[path.Join(d.path, name)].info))
+	}
+	return entries, nil
+}
+
+// tarFile scans the archive up to its entry on the first read, a zip opens the entry itself
+type tarFile struct {
+	fs     *tarFS
+	path   string
+	entry  *tarEntry
+	r      io.Reader
+	closer io.Closer
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) {
+	return f.entry.info, nil
+}
+
+func (f *tarFile) Read(b []byte) (int, error) {
+	if f.r == nil && f.entry.zip != nil {
+		r, err := f.entry.zip.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.r, f.closer = r, r
+	}
+	if f.r == nil {
+		tr, closer, err := tarReader(f.fs.path)
+		if err != nil {
+			return 0, err
+		}
+		f.closer = closer
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				return 0, &fs.PathError{Op: "read", Path: f.path, Err: err}
+			}
+			if cleanTarName(hdr.Name) == f.path {
+				break
+			}
+		}
+		f.r = tr
+	}
+	return f.r.Read(b)
+}
+
+func (f *tarFile) Close() error {
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package walk
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Entry.Status of Diff
+const (
+	Added   = '+'
+	Removed = '-'
+	Changed = '~'
+)
+
+// diffPair is an entry of the merged level, a or b is nil when the entry is on one side only
+type diffPair struct {
+	f fileDir
+	a *fileDir
+	b *fileDir
+}
+
+func (v *walkVars) sameContent(a, b *fileDir, dirA, dirB string, va, vb *walkVars) (bool, error) {
+	if a.size != b.size {
+		return false, nil
+	}
+	if a.hash != "" && b.hash != "" {
+		return a.hash == b.hash, nil // Options.Hash has got them already
+	}
+	if !v.checksum {
+		return a.modTime.Equal(b.modTime), nil
+	}
+	hashA, err := fileHash(va.fsys, subPath(dirA, a.fileName), "sha256")
+	if err != nil {
+		return false, err
+	}
+	hashB, err := fileHash(vb.fsys, subPath(dirB, b.fileName), "sha256")
+	if err != nil {
+		return false, err
+	}
+	return hashA == hashB, nil
+}
+
+func entries(dirs *[]fileDir) []fileDir {
+	if dirs == nil {
+		return nil
+	}
+	return (*dirs)[1:]
+}
+
+// mergeDirs walks the both levels in the name order, like a merge of two sorted lists
+func mergeDirs(a, b *[]fileDir, va, vb, v *walkVars) ([]diffPair, error) {
+	var pairs []diffPair
+	ea, eb := entries(a), entries(b)
+	for len(ea) > 0 || len(eb) > 0 {
+		switch {
+		case len(eb) == 0 || len(ea) > 0 && ea[0].fileName < eb[0].fileName:
+			pairs = append(pairs, diffPair{f: ea[0], a: &ea[0]})
+			ea = ea[1:]
+		case len(ea) == 0 || eb[0].fileName < ea[0].fileName:
+			pairs = append(pairs, diffPair{f: eb[0], b: &eb[0]})
+			eb = eb[1:]
+		case ea[0].isDir != eb[0].isDir:
+			pairs = append(pairs, diffPair{f: ea[0], a: &ea[0]}, diffPair{f: eb[0], b: &eb[0]})
+			ea, eb = ea[1:], eb[1:]
+		default:
+			pair := diffPair{f: eb[0], a: &ea[0], b: &eb[0]}
+			if !pair.f.isDir {
+				same, err := v.sameContent(pair.a, pair.b, (*a)[0].fileName, (*b)[0].fileName, va, vb)
+				if err != nil {
+					if err = v.failed(&pair.f, &Error{"comparing files", err}); err != nil {
+						return nil, err
+					}
+				} else if !same {
+					pair.f.status = Changed
+				}
+			}
+			pairs = append(pairs, pair)
+			ea, eb = ea[1:], eb[1:]
+		}
+	}
+	return pairs, nil
+}
+
+// readSide reads the subdirectory f on one side of the diff, nil when it is not there
+func readSide(dirs *[]fileDir, f *fileDir, vars *walkVars) (*[]fileDir, *walkVars, error) {
+	if f == nil || !vars.follows(f) {
+		return nil, vars, nil
+	}
+	return subDir(dirs, f, vars.enter(f))
+}
+
+// diffTree visits the merged level of a and b, the entries of a missing side are marked as added or removed
+func diffTree(a, b *[]fileDir, va, vb, v *walkVars) error {
+	pairs, err := mergeDirs(a, b, va, vb, v)
+	if err != nil {
+		return err
+	}
+	for n, pair := range pairs {
+		f, last := pair.f, n == len(pairs)-1
+		switch {
+		case pair.b == nil:
+			f.status = Removed
+		case pair.a == nil:
+			f.status = Added
+		}
+
+		if f.isDir == false {
+			if err := v.visitor.File(v.entry(&f, last)); err != nil {
+				return err
+			}
+			continue
+		}
+		var childA, childB *[]fileDir
+		var childVA, childVB *walkVars
+		if v.canDescend() {
+			var errA, errB error
+			childA, childVA, errA = readSide(a, pair.a, va)
+			childB, childVB, errB = readSide(b, pair.b, vb)
+			if err := errors.Join(errA, errB); err != nil {
+				if err = v.failed(&f, err); err != nil {
+					return err
+				}
+			}
+		}
+		if err := v.visitor.EnterDir(v.entry(&f, last)); err != nil {
+			return err
+		}
+		if v.canDescend() && f.err == nil {
+			if err := diffTree(childA, childB, childVA, childVB, v.enter(&f)); err != nil {
+				return err
+			}
+		}
+		if err := v.visitor.LeaveDir(v.entry(&f, last)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diff visits the merged tree of the dirs or the archives at rootA and rootB, the entries are in the name order.
+// Entry.Status tells the entries on one side only and the files changed, the times of the files
+// of the same size are compared unless opts.Checksum is on.
+func Diff(rootA, rootB string, opts Options, visitor Visitor) error {
+	if opts.Sort != "" && opts.Sort != "name" || opts.Reverse || opts.DirsFirst {
+		return fmt.Errorf("diff works only with the name order")
+	}
+	vars, err := opts.vars()
+	if err != nil {
+		return err
+	}
+	vars.visitor = visitor
+	va, vb := *vars, *vars
+	fsysA, closerA, err := Open(rootA)
+	if err != nil {
+		return err
+	}
+	defer closerA.Close()
+	va.setRoot(fsysA)
+	fsysB, closerB, err := Open(rootB)
+	if err != nil {
+		return err
+	}
+	defer closerB.Close()
+	vb.setRoot(fsysB)
+
+	dirA, err := readDir(".", &va)
+	if err != nil {
+		return err
+	}
+	dirB, err := readDir(".", &vb)
+	if err != nil {
+		return err
+	}
+	return diffTree(dirA, dirB, &va, &vb, vars)
+}
@@ -0,0 +1,143 @@
+package walk
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+type patterns []string
+
+func (p patterns) match(name string) bool {
+	for _, pattern := range p {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type ignoreRule struct {
+	base     string // dir of the .gitignore relative to the tree root
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func (r ignoreRule) match(rel, name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+	if r.anchored {
+		return matchGlob(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+	}
+	ok, _ := path.Match(r.pattern, name)
+	return ok
+}
+
+// matchGlob matches slash separated segments, "**" stands for any number of them
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func readGitignore(fsys fs.FS, file, base string) ([]ignoreRule, error) {
+	f, err := fsys.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// isPruned reports whether an entry is hidden by Exclude, Include or .gitignore rules
+func isPruned(name string, isDir bool, v *walkVars) bool {
+	if v.excludes.match(name) {
+		return true
+	}
+	if !isDir && len(v.includes) > 0 && !v.includes.match(name) {
+		return true
+	}
+
+	rel := name
+	if v.rel != "" {
+		rel = v.rel + "/" + name
+	}
+	ignored := false
+	for _, rule := range v.ignores {
+		if rule.match(rel, name, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// pruneMatches keeps the entries matching Match and the dirs with something kept inside.
+// The dirs which could not be read are kept too, there may be matches in them.
+func pruneMatches(dirs *[]fileDir, v *walkVars) {
+	kept := (*dirs)[:1]
+	for _, f := range (*dirs)[1:] {
+		f.matched = v.matches.match(f.fileName)
+		if f.matched || f.err != nil || f.children != nil && len(*f.children) > 1 {
+			kept = append(kept, f)
+		}
+	}
+	*dirs = kept
+}
@@ -0,0 +1,116 @@
+package walk
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// Hashes are the algorithms of Options.Hash
+var Hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func fileHash(fsys fs.FS, name, algorithm string) (string, error) {
+	if _, ok := Hashes[algorithm]; !ok {
+		return "", fmt.Errorf("unknown hash %q, use md5, sha1, sha256 or sha512", algorithm)
+	}
+	if t, ok := fsys.(*tarFS); ok && t.zip == nil {
+		return t.digest(name, algorithm)
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readHash(f, algorithm)
+}
+
+func readHash(r io.Reader, algorithm string) (string, error) {
+	h := Hashes[algorithm]()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFiles fills the digests of the files of the level
+func hashFiles(dirs *[]fileDir, v *walkVars) error {
+	for n := 1; n < len(*dirs); n++ {
+		f := &(*dirs)[n]
+		if f.isDir {
+			continue
+		}
+		sum, err := fileHash(v.fsys, subPath((*dirs)[0].fileName, f.fileName), v.hash)
+		if err != nil {
+			if err = v.failed(f, &Error{"hashing file", err}); err != nil {
+				return err
+			}
+			continue
+		}
+		f.hash = sum
+	}
+	return nil
+}
+
+type ownerNames struct {
+	mu     sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+var owners = &ownerNames{users: map[uint32]string{}, groups: map[uint32]string{}}
+
+func (o *ownerNames) lookup(id uint32, names map[uint32]string, find func(string) (string, error)) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if name, ok := names[id]; ok {
+		return name
+	}
+	name, err := find(strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		name = strconv.FormatUint(uint64(id), 10)
+	}
+	names[id] = name
+	return name
+}
+
+func findUser(uid string) (string, error) {
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+func findGroup(gid string) (string, error) {
+	g, err := user.LookupGroupId(gid)
+	if err != nil {
+		return "", err
+	}
+	return g.Name, nil
+}
+
+// fileOwner gives the user and the group names, the archives keep the names themselves
+func fileOwner(info fs.FileInfo) (string, string) {
+	if ti, ok := info.(tarInfo); ok {
+		return ti.user, ti.group
+	}
+	uid, gid, ok := sysOwner(info.Sys())
+	if !ok {
+		return "", ""
+	}
+	return owners.lookup(uid, owners.users, findUser), owners.lookup(gid, owners.groups, findGroup)
+}
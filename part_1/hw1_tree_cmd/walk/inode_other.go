@@ -0,0 +1,12 @@
+//go:build !unix
+
+package walk
+
+// sysFileID knows no inodes here, loops are detected only inside archives
+func sysFileID(sys any) (fileID, bool) {
+	return fileID{}, false
+}
+
+func sysOwner(sys any) (uint32, uint32, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package walk
+
+import "syscall"
+
+func sysFileID(sys any) (fileID, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+func sysOwner(sys any) (uint32, uint32, bool) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
@@ -0,0 +1,108 @@
+package walk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type lessFunc func(a, b *fileDir) bool
+
+func byName(a, b *fileDir) bool {
+	return a.fileName < b.fileName
+}
+
+// bySize puts the biggest first
+func bySize(a, b *fileDir) bool {
+	if a.size != b.size {
+		return a.size > b.size
+	}
+	return byName(a, b)
+}
+
+// byMtime puts the oldest first
+func byMtime(a, b *fileDir) bool {
+	if !a.modTime.Equal(b.modTime) {
+		return a.modTime.Before(b.modTime)
+	}
+	return byName(a, b)
+}
+
+func byVersion(a, b *fileDir) bool {
+	if c := compareVersions(a.fileName, b.fileName); c != 0 {
+		return c < 0
+	}
+	return byName(a, b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// chunk cuts the leading run of digits or of non digits
+func chunk(s string) (string, string) {
+	i := 1
+	for i < len(s) && isDigit(s[i]) == isDigit(s[0]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareVersions compares numbers inside the names by value, so file2 < file10
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		var ca, cb string
+		ca, a = chunk(a)
+		cb, b = chunk(b)
+		if isDigit(ca[0]) && isDigit(cb[0]) {
+			na, nb := strings.TrimLeft(ca, "0"), strings.TrimLeft(cb, "0")
+			if len(na) != len(nb) {
+				return len(na) - len(nb)
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			continue
+		}
+		if c := strings.Compare(ca, cb); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func newLess(sortBy string, reverse, dirsFirst bool) (lessFunc, error) {
+	sorts := map[string]lessFunc{"name": byName, "size": bySize, "mtime": byMtime, "version": byVersion}
+	less, ok := sorts[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q, use name, size, mtime or version", sortBy)
+	}
+	if reverse {
+		forward := less
+		less = func(a, b *fileDir) bool {
+			return forward(b, a)
+		}
+	}
+	if dirsFirst {
+		inner := less
+		less = func(a, b *fileDir) bool {
+			if a.isDir != b.isDir {
+				return a.isDir
+			}
+			return inner(a, b)
+		}
+	}
+	return less, nil
+}
+
+// sortDir sorts the entries after the header
+func sortDir(dirs *[]fileDir, v *walkVars) {
+	less := v.less
+	if less == nil {
+		less = byName
+	}
+	entries := (*dirs)[1:]
+	sort.SliceStable(entries, func(i, j int) bool {
+		return less(&entries[i], &entries[j])
+	})
+}
@@ -0,0 +1,318 @@
+package walk
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+type walkVars struct {
+	fsys        fs.FS
+	visitor     Visitor
+	printFiles  bool
+	maxDepth    int // 0 means no limit
+	depth       int
+	excludes    patterns
+	includes    patterns
+	matches     patterns // only the branches leading to these are visited
+	gitignore   bool
+	ignores     []ignoreRule
+	rel         string // current dir relative to the tree root
+	du          bool
+	less        lessFunc // nil sorts by name
+	followLinks bool
+	ancestors   []fileID // dirs on the way from the root, for loop detection
+	walker      *walker  // nil reads the dirs one by one
+	checksum    bool
+	owners      bool
+	hash        string // algorithm, empty for no digest
+	strict      bool   // stop at the first unreadable entry
+}
+
+type fileDir struct {
+	fileName  string
+	isDir     bool
+	size      int64
+	mode      fs.FileMode
+	modTime   time.Time
+	link      string // target of a symlink
+	recursive bool   // the link points to one of the parents
+	id        fileID
+	children  *[]fileDir  // loaded ahead of the visitor for DiskUsage
+	childVars *walkVars   // children were read with, they have the .gitignore rules of f
+	pending   *pendingDir // being read ahead of the visitor for Parallel
+	status    byte        // Added, Removed or Changed in Diff, 0 otherwise
+	user      string
+	group     string
+	hash      string
+	err       error // why the dir or the file could not be read
+	matched   bool  // the name matches Options.Match
+}
+
+// Error is an entry the walk could not read, it is in Entry.Err
+type Error struct {
+	Op  string // "opening dir", "reading dir", ...
+	Err error
+}
+
+func (e *Error) Error() string {
+	return "error " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func walkTree(dirs *[]fileDir, v *walkVars) error {
+	l := len(*dirs) - 1
+	if l == 0 {
+		return nil // only one path, no files to visit
+	}
+	if v.walker != nil {
+		v.walker.prefetch(dirs, v)
+	}
+
+	for n := 1; n <= l; n++ {
+		f := (*dirs)[n]
+		if f.isDir == false {
+			if err := v.visitor.File(v.entry(&f, n == l)); err != nil {
+				return err
+			}
+			continue
+		}
+		// the dir is read before it is visited, so its error goes with it
+		var children *[]fileDir
+		var childVars *walkVars
+		if v.canDescend() && v.follows(&f) {
+			var err error
+			if children, childVars, err = subDir(dirs, &f, v.enter(&f)); err != nil {
+				if err = v.failed(&f, err); err != nil {
+					return err
+				}
+			}
+		}
+		if err := v.visitor.EnterDir(v.entry(&f, n == l)); err != nil {
+			return err
+		}
+		if children != nil {
+			if err := walkTree(children, childVars); err != nil {
+				return err
+			}
+		}
+		if err := v.visitor.LeaveDir(v.entry(&f, n == l)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subDir gets the entries of the subdirectory f, read ahead or not
+func subDir(dirs *[]fileDir, f *fileDir, vars *walkVars) (*[]fileDir, *walkVars, error) {
+	switch {
+	case f.err != nil:
+		return nil, vars, f.err
+	case f.children != nil:
+		return f.children, f.childVars, nil
+	case f.pending != nil:
+		return f.pending.wait()
+	}
+	children, err := readDir(subPath((*dirs)[0].fileName, f.fileName), vars)
+	return children, vars, err
+}
+
+// failed keeps err on f for the visitor and goes on, with Strict it stops the walk instead
+func (v *walkVars) failed(f *fileDir, err error) error {
+	if v.strict {
+		return err
+	}
+	f.err = err
+	return nil
+}
+
+func (v *walkVars) canDescend() bool {
+	return v.maxDepth == 0 || v.depth+1 < v.maxDepth
+}
+
+// follows reports whether the walk goes inside the directory f
+func (v *walkVars) follows(f *fileDir) bool {
+	return (f.link == "" || v.followLinks) && !f.recursive
+}
+
+// enter makes vars for the walk of the subdirectory f
+func (v *walkVars) enter(f *fileDir) *walkVars {
+	vars := *v
+	vars.depth++
+	vars.ignores = v.ignores[:len(v.ignores):len(v.ignores)]
+	vars.ancestors = append(v.ancestors[:len(v.ancestors):len(v.ancestors)], f.id)
+	if v.rel == "" {
+		vars.rel = f.fileName
+	} else {
+		vars.rel = v.rel + "/" + f.fileName
+	}
+	return &vars
+}
+
+func (v *walkVars) isAncestor(id fileID) bool {
+	if id == (fileID{}) {
+		return false
+	}
+	for _, ancestor := range v.ancestors {
+		if ancestor == id {
+			return true
+		}
+	}
+	return false
+}
+
+// subPath joins the fs path of a dir with the name of its entry
+func subPath(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func getSortedDir(files []fs.FileInfo, v *walkVars, dir string) *[]fileDir {
+	var myDir = make([]fileDir, 1)
+	var size int64
+
+	for f := range files {
+		info := files[f]
+		entry := fileDir{fileName: info.Name(), isDir: info.IsDir()}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			entry.link, _ = fs.ReadLink(v.fsys, subPath(dir, entry.fileName))
+			if target, err := fs.Stat(v.fsys, subPath(dir, entry.fileName)); err == nil {
+				entry.isDir = target.IsDir()
+				if v.followLinks {
+					info = target
+				}
+			}
+		}
+		if isPruned(entry.fileName, entry.isDir, v) {
+			continue
+		}
+		entry.mode, entry.modTime = info.Mode(), info.ModTime()
+		entry.id, _ = getFileID(info)
+		if v.owners {
+			entry.user, entry.group = fileOwner(info)
+		}
+		if entry.isDir == false {
+			entry.size = info.Size()
+			size += entry.size
+			if v.printFiles == true {
+				myDir = append(myDir, entry)
+			}
+		} else {
+			entry.recursive = entry.link != "" && v.followLinks && v.isAncestor(entry.id)
+			myDir = append(myDir, entry)
+		}
+	}
+
+	myDir[0] = fileDir{fileName: dir, size: size}
+	sortDir(&myDir, v)
+
+	return &myDir
+}
+
+// loadSizes reads the subdirectories ahead of the visitor, so every dir gets
+// the size of all the files below it, even deeper than MaxDepth allows to visit
+func loadSizes(dirs *[]fileDir, v *walkVars) error {
+	for n := 1; n < len(*dirs); n++ {
+		f := &(*dirs)[n]
+		if f.isDir == false || !v.follows(f) {
+			continue
+		}
+		childVars := v.enter(f)
+		children, err := readDir(subPath((*dirs)[0].fileName, f.fileName), childVars)
+		if err != nil {
+			if err = v.failed(f, err); err != nil {
+				return err
+			}
+			continue
+		}
+		f.children, f.childVars = children, childVars
+		f.size = (*children)[0].size
+		(*dirs)[0].size += f.size
+	}
+	return nil
+}
+
+func listDir(path string, vars *walkVars) ([]fs.FileInfo, error) {
+	if vars.walker != nil {
+		vars.walker.acquire()
+		defer vars.walker.release()
+	}
+
+	file, err := vars.fsys.Open(path)
+	if err != nil {
+		return nil, &Error{"opening dir", err}
+	}
+	defer file.Close()
+
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		return nil, &Error{"opening dir", &fs.PathError{Op: "readdir", Path: path, Err: errors.New("not a directory")}}
+	}
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, &Error{"reading dir", err}
+	}
+	files := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, &Error{"reading dir", err}
+		}
+		files = append(files, info)
+	}
+
+	if vars.gitignore && len(files) > 0 {
+		rules, err := readGitignore(vars.fsys, subPath(path, ".gitignore"), vars.rel)
+		if err != nil {
+			return nil, &Error{"reading .gitignore", err}
+		}
+		vars.ignores = append(vars.ignores, rules...)
+	}
+	return files, nil
+}
+
+func readDir(path string, vars *walkVars) (*[]fileDir, error) {
+	files, err := listDir(path, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	currentDir := getSortedDir(files, vars, path)
+	if vars.hash != "" {
+		if err := hashFiles(currentDir, vars); err != nil {
+			return nil, err
+		}
+	}
+	// Match has to know which subdirectories keep something before visiting their parent
+	if vars.du || len(vars.matches) > 0 && vars.canDescend() {
+		if vars.walker != nil {
+			err = vars.walker.loadSizes(currentDir, vars)
+		} else {
+			err = loadSizes(currentDir, vars)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if vars.du {
+			sortDir(currentDir, vars) // dir sizes are known only now
+		}
+	}
+	if len(vars.matches) > 0 {
+		pruneMatches(currentDir, vars)
+	}
+	return currentDir, nil
+}
+
+// setRoot sets vars up for the walk of fsys
+func (v *walkVars) setRoot(fsys fs.FS) {
+	v.fsys = fsys
+	if info, err := fs.Stat(fsys, "."); err == nil {
+		id, _ := getFileID(info)
+		v.ancestors = []fileID{id}
+	}
+}
@@ -0,0 +1,168 @@
+// Package walk walks a directory or an archive tree in a sorted order for a Visitor,
+// with the filters, the sizes and the metadata of the tree command.
+package walk
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// Options tune Walk, the zero value visits the directories of the whole tree in the name order
+type Options struct {
+	Files       bool     // visit the files too
+	MaxDepth    int      // 0 means no limit
+	Exclude     []string // entries matching one of the patterns are skipped
+	Include     []string // only the files matching one of the patterns are visited
+	Match       []string // only the entries matching one of the patterns and the dirs leading to them are visited
+	Gitignore   bool     // skip the entries ignored by .gitignore files
+	DiskUsage   bool     // dir sizes are the sums of the files below them
+	Sort        string   // name, size, mtime or version, empty is name
+	Reverse     bool
+	DirsFirst   bool
+	FollowLinks bool   // walk into symbolic links to directories
+	Parallel    int    // directories read at once, 0 reads them one by one
+	Strict      bool   // stop at the first unreadable entry instead of passing it in Entry.Err
+	Owners      bool   // fill Entry.User and Entry.Group
+	Hash        string // fill Entry.Hash with the md5, sha1, sha256 or sha512 digest of the files
+	Checksum    bool   // Diff compares the contents of the files of the same size, not the times
+}
+
+// Entry is a file or a directory met by Walk
+type Entry struct {
+	Name      string
+	Path      string // slash separated, relative to the root
+	Depth     int    // 0 for the entries of the root
+	Last      bool   // the last one of its directory
+	IsDir     bool
+	Size      int64
+	Mode      fs.FileMode
+	ModTime   time.Time
+	Link      string // target of a symlink
+	Recursive bool   // the link points to one of the parents, it is not walked into
+	Status    byte   // Added, Removed or Changed in Diff, 0 otherwise
+	User      string
+	Group     string
+	Hash      string
+	Err       error // why the directory or the file could not be read, an *Error most of the time
+	Matched   bool  // the name matches Options.Match
+}
+
+// Visitor gets the entries in the order of the walk, every EnterDir is paired with LeaveDir.
+// An error returned by the visitor stops the walk.
+type Visitor interface {
+	EnterDir(e Entry) error
+	File(e Entry) error
+	LeaveDir(e Entry) error
+}
+
+// RootVisitor is a Visitor told about the root before its entries,
+// Size is the one of the whole tree with Options.DiskUsage
+type RootVisitor interface {
+	Visitor
+	Root(e Entry) error
+}
+
+// Walk visits the tree of the dir or the archive at root.
+// The entries that could not be read are visited with Entry.Err set unless opts.Strict is on.
+func Walk(root string, opts Options, visitor Visitor) error {
+	fsys, closer, err := Open(root)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	return WalkFS(fsys, opts, visitor)
+}
+
+// WalkFS is Walk of a tree already open
+func WalkFS(fsys fs.FS, opts Options, visitor Visitor) error {
+	vars, err := opts.vars()
+	if err != nil {
+		return err
+	}
+	vars.setRoot(fsys)
+	vars.visitor = visitor
+	dir, err := readDir(".", vars)
+	if err != nil {
+		return err
+	}
+	if rv, ok := visitor.(RootVisitor); ok {
+		if err := rv.Root(Entry{Name: ".", Path: ".", Depth: -1, IsDir: true, Size: (*dir)[0].size}); err != nil {
+			return err
+		}
+	}
+	return walkTree(dir, vars)
+}
+
+func (o Options) Validate() error {
+	if o.MaxDepth < 0 {
+		return fmt.Errorf("invalid level %d, must be greater than 0", o.MaxDepth)
+	}
+	if _, ok := Hashes[o.Hash]; o.Hash != "" && !ok {
+		return fmt.Errorf("unknown hash %q, use md5, sha1, sha256 or sha512", o.Hash)
+	}
+	if o.Parallel < 0 {
+		return fmt.Errorf("invalid number of workers %d", o.Parallel)
+	}
+	_, err := o.less()
+	return err
+}
+
+func (o Options) less() (lessFunc, error) {
+	if o.Sort == "" {
+		o.Sort = "name"
+	}
+	return newLess(o.Sort, o.Reverse, o.DirsFirst)
+}
+
+func (o Options) vars() (*walkVars, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	less, _ := o.less()
+	vars := &walkVars{
+		printFiles:  o.Files,
+		maxDepth:    o.MaxDepth,
+		excludes:    o.Exclude,
+		includes:    o.Include,
+		matches:     o.Match,
+		gitignore:   o.Gitignore,
+		du:          o.DiskUsage,
+		less:        less,
+		followLinks: o.FollowLinks,
+		checksum:    o.Checksum,
+		owners:      o.Owners,
+		hash:        o.Hash,
+		strict:      o.Strict,
+	}
+	if o.Parallel > 0 {
+		vars.walker = newWalker(o.Parallel)
+	}
+	return vars, nil
+}
+
+// entry is what the visitor gets of f
+func (v *walkVars) entry(f *fileDir, last bool) Entry {
+	path := f.fileName
+	if v.rel != "" {
+		path = v.rel + "/" + f.fileName
+	}
+	return Entry{
+		Name:      f.fileName,
+		Path:      path,
+		Depth:     v.depth,
+		Last:      last,
+		IsDir:     f.isDir,
+		Size:      f.size,
+		Mode:      f.mode,
+		ModTime:   f.modTime,
+		Link:      f.link,
+		Recursive: f.recursive,
+		Status:    f.status,
+		User:      f.user,
+		Group:     f.group,
+		Hash:      f.hash,
+		Err:       f.err,
+		Matched:   f.matched,
+	}
+}
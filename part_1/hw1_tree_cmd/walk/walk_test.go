@@ -0,0 +1,141 @@
+package walk
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func makeTree(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// recorder keeps the events of Walk as lines
+type recorder []string
+
+func (r *recorder) add(event string, e Entry) error {
+	line := event + " " + e.Path + " " + strconv.Itoa(e.Depth) + " " + strconv.FormatBool(e.Last) + " " + strconv.FormatInt(e.Size, 10)
+	if e.Status != 0 {
+		line += " " + string(e.Status)
+	}
+	*r = append(*r, line)
+	return nil
+}
+
+func (r *recorder) EnterDir(e Entry) error { return r.add("enter", e) }
+func (r *recorder) File(e Entry) error     { return r.add("file", e) }
+func (r *recorder) LeaveDir(e Entry) error { return r.add("leave", e) }
+
+// rootRecorder gets the root too
+type rootRecorder struct {
+	recorder
+}
+
+func (r *rootRecorder) Root(e Entry) error { return r.add("root", e) }
+
+func TestWalk(t *testing.T) {
+	root := makeTree(t, map[string]string{"a.txt": "abc", "dir/sub/x": "12", "dir/y": ""})
+	expected := []string{
+		"enter dir 0 false 2",
+		"enter dir/sub 1 false 2",
+		"file dir/sub/x 2 true 2",
+		"leave dir/sub 1 false 2",
+		"file dir/y 1 true 0",
+		"leave dir 0 false 2",
+		"file a.txt 0 true 3",
+	}
+	for _, workers := range []int{0, 4} {
+		var events recorder
+		opts := Options{Files: true, DiskUsage: true, DirsFirst: true, Parallel: workers}
+		if err := Walk(root, opts, &events); err != nil {
+			t.Fatalf("test for Walk Failed - error: %v", err)
+		}
+		if result, expected := strings.Join(events, "\n"), strings.Join(expected, "\n"); result != expected {
+			t.Errorf("test for Walk with %d workers Failed - results not match\nGot:\n%v\nExpected:\n%v", workers, result, expected)
+		}
+	}
+
+	// the size of the root has the files not visited too
+	events := &rootRecorder{}
+	if err := Walk(root, Options{DiskUsage: true}, events); err != nil {
+		t.Fatalf("test for Walk Failed - error: %v", err)
+	}
+	expected = []string{"root . -1 false 5", "enter dir 0 true 2", "enter dir/sub 1 true 2", "leave dir/sub 1 true 2", "leave dir 0 true 2"}
+	if result, expected := strings.Join(events.recorder, "\n"), strings.Join(expected, "\n"); result != expected {
+		t.Errorf("test for RootVisitor Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	if err := Walk(root, Options{Sort: "color"}, new(recorder)); err == nil {
+		t.Errorf("test for Walk Failed - expected an error for the unknown sort")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := makeTree(t, map[string]string{"dir/f": "ab", "dir/same": "x", "old": ""})
+	b := makeTree(t, map[string]string{"dir/f": "ac", "dir/same": "x", "new": ""})
+	expected := []string{
+		"enter dir 0 false 0",
+		"file dir/f 1 false 2 ~",
+		"file dir/same 1 true 1",
+		"leave dir 0 false 0",
+		"file new 0 false 0 +",
+		"file old 0 true 0 -",
+	}
+	var events recorder
+	if err := Diff(a, b, Options{Files: true, Checksum: true}, &events); err != nil {
+		t.Fatalf("test for Diff Failed - error: %v", err)
+	}
+	if result, expected := strings.Join(events, "\n"), strings.Join(expected, "\n"); result != expected {
+		t.Errorf("test for Diff Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+
+	if err := Diff(a, b, Options{Reverse: true}, new(recorder)); err == nil {
+		t.Errorf("test for Diff Failed - expected an error for the reverse order")
+	}
+}
+
+func TestTarDigest(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "files.tar")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for _, file := range []string{"a", "dir/b"} {
+		tw.WriteHeader(&tar.Header{Name: file, Mode: 0644, Size: int64(len(file))})
+		tw.Write([]byte(file))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fsys, closer, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	expected := map[string]string{"a": "0cc175b9c0f1b6a831c399e269772661", "dir/b": "d9867d6e65d9dfe7fe4574c3a30dbe9b"}
+	if sum, err := fileHash(fsys, "a", "md5"); err != nil || sum != expected["a"] {
+		t.Errorf("wrong digest of a\nGot: %v, %v\nExpected: %v", sum, err, expected["a"])
+	}
+	// all the files are hashed in the first pass, the archive is not read again
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := fileHash(fsys, "dir/b", "md5"); err != nil || sum != expected["dir/b"] {
+		t.Errorf("wrong digest of dir/b\nGot: %v, %v\nExpected: %v", sum, err, expected["dir/b"])
+	}
+}
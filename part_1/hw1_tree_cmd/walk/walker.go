@@ -0,0 +1,90 @@
+package walk
+
+import "sync"
+
+// walker reads directories ahead of the visitor, at most cap(sem) of them at once.
+// The visitor still gets them in the walk order, so the output does not change.
+type walker struct {
+	sem chan struct{}
+}
+
+type pendingDir struct {
+	done chan struct{}
+	dir  *[]fileDir
+	vars *walkVars // the dir is read with
+	err  error
+}
+
+func newWalker(workers int) *walker {
+	return &walker{sem: make(chan struct{}, workers)}
+}
+
+func (w *walker) acquire() {
+	w.sem <- struct{}{}
+}
+
+func (w *walker) release() {
+	<-w.sem
+}
+
+func (w *walker) read(path string, vars *walkVars) *pendingDir {
+	pending := &pendingDir{done: make(chan struct{}), vars: vars}
+	go func() {
+		pending.dir, pending.err = readDir(path, vars)
+		close(pending.done)
+	}()
+	return pending
+}
+
+func (p *pendingDir) wait() (*[]fileDir, *walkVars, error) {
+	<-p.done
+	return p.dir, p.vars, p.err
+}
+
+// prefetch starts reading all the subdirectories the walk is going to enter
+func (w *walker) prefetch(dirs *[]fileDir, v *walkVars) {
+	if !v.canDescend() {
+		return
+	}
+	for n := 1; n < len(*dirs); n++ {
+		f := &(*dirs)[n]
+		if f.isDir && f.children == nil && v.follows(f) {
+			f.pending = w.read(subPath((*dirs)[0].fileName, f.fileName), v.enter(f))
+		}
+	}
+}
+
+// loadSizes is loadSizes with the subdirectories read in parallel
+func (w *walker) loadSizes(dirs *[]fileDir, v *walkVars) error {
+	wg := &sync.WaitGroup{}
+	errs := make([]error, len(*dirs))
+	for n := 1; n < len(*dirs); n++ {
+		f := &(*dirs)[n]
+		if f.isDir == false || !v.follows(f) {
+			continue
+		}
+		wg.Add(1)
+		go func(n int, f *fileDir) {
+			defer wg.Done()
+			f.childVars = v.enter(f)
+			f.children, errs[n] = readDir(subPath((*dirs)[0].fileName, f.fileName), f.childVars)
+		}(n, f)
+	}
+	wg.Wait()
+
+	// sum up in order so the first error is the one the serial walk would get
+	for n := 1; n < len(*dirs); n++ {
+		f := &(*dirs)[n]
+		if errs[n] != nil {
+			if err := v.failed(f, errs[n]); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.children != nil {
+			f.size = (*f.children)[0].size
+			(*dirs)[0].size += f.size
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"hw1_tree_cmd/walk"
+)
+
+// settleTime is how long the changes are collected before the tree is read again,
+// a copy or an unpacked archive makes a lot of them at once
+const settleTime = 100 * time.Millisecond
+
+// dirWatcher tells when the entries of the watched dirs change
+type dirWatcher interface {
+	sync(dirs []string) error // watch exactly these dirs, they are relative to the root
+	changes() <-chan error    // nil for a change, an error ends the watch
+	Close() error
+}
+
+// watchVisitor collects the dirs whose entries are in the tree, only they are watched
+type watchVisitor struct {
+	walk.Visitor
+	vars *mainVars
+	dirs []string
+}
+
+func (w *watchVisitor) EnterDir(e walk.Entry) error {
+	opts := w.vars.opts
+	descends := opts.MaxDepth == 0 || e.Depth+1 < opts.MaxDepth
+	if descends && e.Err == nil && !e.Recursive && (e.Link == "" || opts.FollowLinks) {
+		w.dirs = append(w.dirs, e.Path)
+	}
+	return w.Visitor.EnterDir(e)
+}
+
+// renderWatched prints the tree of path to out and gives the dirs to watch
+func renderWatched(path string, vars *mainVars, out io.Writer) ([]string, error) {
+	v := *vars
+	v.out, v.failures = out, new(int)
+	if vars.report != nil {
+		v.report = &treeReport{}
+	}
+	fsys, closer, err := walk.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	printer := newPrinter(&v)
+	watched := &watchVisitor{Visitor: printer, vars: &v, dirs: []string{"."}}
+	v.visitor = watched
+	if err := printRoot(path, fsys, printer, &v); err != nil && *v.failures == 0 {
+		return nil, err // the unreadable entries are in the tree already, the rest is fatal
+	}
+	return watched.dirs, nil
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// watchTree prints the tree and prints it again every time it looks different, until stop is closed
+func watchTree(path string, vars *mainVars, stop <-chan struct{}) error {
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return fmt.Errorf("--watch works only with directories")
+	}
+	w, err := newWatcher(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	separator := "\n"
+	if isTerminal(vars.out) {
+		separator = "\x1b[H\x1b[2J" // clear the screen
+	}
+	var last []byte
+	for {
+		out := new(bytes.Buffer)
+		dirs, err := renderWatched(path, vars, out)
+		if err != nil {
+			return err
+		}
+		// a change of an entry not shown, like the time of a file, does not print the same tree again
+		if !bytes.Equal(out.Bytes(), last) {
+			if last != nil {
+				if _, err := io.WriteString(vars.out, separator); err != nil {
+					return err
+				}
+			}
+			if _, err := vars.out.Write(out.Bytes()); err != nil {
+				return err
+			}
+			last = out.Bytes()
+		}
+		if err := w.sync(dirs); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case err := <-w.changes():
+			if err != nil {
+				return err
+			}
+		}
+		settle := time.After(settleTime)
+	collect:
+		for {
+			select {
+			case <-stop:
+				return nil
+			case err := <-w.changes():
+				if err != nil {
+					return err
+				}
+			case <-settle:
+				break collect
+			}
+		}
+	}
+}
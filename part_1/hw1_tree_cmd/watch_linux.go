@@ -0,0 +1,104 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyWatcher keeps one inotify watch for every dir of the tree
+type inotifyWatcher struct {
+	root    string
+	fd      int
+	file    *os.File // the nonblocking fd in the runtime poller, Close stops the read
+	wds     map[int]bool
+	changed chan error
+}
+
+func newWatcher(root string) (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("can't watch the tree: %w", err)
+	}
+	w := &inotifyWatcher{
+		root:    root,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		wds:     map[int]bool{},
+		changed: make(chan error, 1),
+	}
+	go w.read()
+	return w, nil
+}
+
+// sync adds the watches of dirs and removes the others.
+// A dir reached by two paths gets the same watch descriptor, so they are compared, not the paths.
+func (w *inotifyWatcher) sync(dirs []string) error {
+	wds := map[int]bool{}
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(w.fd, filepath.Join(w.root, filepath.FromSlash(dir)), watchMask)
+		if errors.Is(err, syscall.ENOSPC) {
+			return fmt.Errorf("too many directories to watch, see fs.inotify.max_user_watches: %w", err)
+		}
+		if err != nil {
+			continue // it is gone since the walk, its parent tells about that
+		}
+		wds[wd] = true
+	}
+	for wd := range w.wds {
+		if !wds[wd] {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+		}
+	}
+	w.wds = wds
+	return nil
+}
+
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		}
+		if err != nil {
+			w.changed <- fmt.Errorf("can't watch the tree: %w", err)
+			return
+		}
+		if !hasChanges(buf[:n]) {
+			continue
+		}
+		select {
+		case w.changed <- nil:
+		default: // one is waiting already
+		}
+	}
+}
+
+// hasChanges skips the events of the removed watches
+func hasChanges(buf []byte) bool {
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		if event.Mask&syscall.IN_IGNORED == 0 {
+			return true
+		}
+		buf = buf[syscall.SizeofInotifyEvent+int(event.Len):]
+	}
+	return false
+}
+
+func (w *inotifyWatcher) changes() <-chan error {
+	return w.changed
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func newWatcher(root string) (dirWatcher, error) {
+	return nil, errors.New("--watch works only on linux")
+}
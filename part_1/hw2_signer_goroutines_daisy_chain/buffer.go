@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// adaptEvery is how many values the adaptive mode looks at before it grows a buffer
+const adaptEvery = 16
+
+// BufferConfig sizes the output of a stage
+type BufferConfig struct {
+	Size int // values the stage can give ahead of the next one, 0 is 1 like the channels of Then
+	// Adaptive doubles Size up to Max when the stage was blocked on the full buffer
+	// while the next one waited on the empty buffer longer, it is faster but gets the values in bursts
+	Adaptive bool
+	Max      int // 0 is 1024
+}
+
+// BufferStats tells how the stages around a buffer waited on each other
+type BufferStats struct {
+	Size   int // the current one, it grows in the adaptive mode
+	Values int
+	Grows  int
+	// FullTime is how long the buffer was full, the stage was blocked on sending as soon as it had a value
+	FullTime time.Duration
+	// EmptyTime is how long the buffer was empty, the next stage was blocked on receiving as soon as it wanted a value
+	EmptyTime time.Duration
+}
+
+// Buffer is the output buffer of one stage, its size and stats go on over the runs
+type Buffer struct {
+	mu    sync.Mutex
+	cfg   BufferConfig
+	stats BufferStats
+	// of the values since the last look of the adaptive mode
+	window      int
+	full, empty time.Duration
+}
+
+func NewBuffer(cfg BufferConfig) *Buffer {
+	if cfg.Max <= 0 {
+		cfg.Max = 1024
+	}
+	return &Buffer{cfg: cfg, stats: BufferStats{Size: max(cfg.Size, 1)}}
+}
+
+func (b *Buffer) Stats() BufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+func (b *Buffer) size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats.Size
+}
+
+// waited adds the time the buffer spent empty or full
+func (b *Buffer) waited(empty, full bool, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if empty {
+		b.stats.EmptyTime += d
+		b.empty += d
+	}
+	if full {
+		b.stats.FullTime += d
+		b.full += d
+	}
+}
+
+func (b *Buffer) received() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Values++
+	if !b.cfg.Adaptive {
+		return
+	}
+	b.window++
+	if b.window < adaptEvery {
+		return
+	}
+	if b.full > 0 && b.empty > b.full && b.stats.Size < b.cfg.Max {
+		b.stats.Size = min(2*b.stats.Size, b.cfg.Max)
+		b.stats.Grows++
+	}
+	b.window, b.full, b.empty = 0, 0, 0
+}
+
+// Buffered lets the stage give up to the size of b values ahead of the next stage
+func Buffered[In, Out any](b *Buffer, stage Stage[In, Out]) Stage[In, Out] {
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		given := make(chan Out)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pump(ctx, b, given, out)
+		}()
+		err := stage.run(ctx, in, given)
+		close(given)
+		<-done
+		return err
+	}
+}
+
+// pump queues the values of in until out takes them, the queue is not longer than the size of b
+func pump[T any](ctx context.Context, b *Buffer, in, out chan T) {
+	var queue []T
+	for in != nil || len(queue) > 0 {
+		var receive, give chan T
+		var next T
+		if in != nil && len(queue) < b.size() {
+			receive = in
+		}
+		if len(queue) > 0 {
+			give, next = out, queue[0]
+		}
+
+		empty, full := len(queue) == 0, in != nil && receive == nil
+		start := time.Now()
+		select {
+		case val, ok := <-receive:
+			b.waited(empty, full, time.Since(start))
+			if !ok {
+				in = nil
+				continue
+			}
+			queue = append(queue, val)
+			b.received()
+		case give <- next:
+			b.waited(empty, full, time.Since(start))
+			var zero T
+			queue[0] = zero
+			queue = queue[1:]
+		case <-ctx.Done():
+			if in != nil {
+				drain(in)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type cacheKey struct {
+	signer string
+	data   string
+}
+
+type cacheEntry struct {
+	key cacheKey
+	val string
+}
+
+// call is a computation the callers of the same key wait for
+type call struct {
+	done chan struct{}
+	val  string
+	err  error
+}
+
+// CacheStats count the calls of Do, the ones sharing a running computation are hits too
+type CacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// Cache keeps the last size results of the signers, the calls of the same data at once share one computation
+type Cache struct {
+	mu       sync.Mutex
+	size     int
+	entries  map[cacheKey]*list.Element
+	order    *list.List // of cacheEntry, the most recent first
+	inflight map[cacheKey]*call
+	stats    CacheStats
+}
+
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:     size,
+		entries:  map[cacheKey]*list.Element{},
+		order:    list.New(),
+		inflight: map[cacheKey]*call{},
+	}
+}
+
+// Do gives the result of compute for the signer and the data, computed once.
+// The errors are not kept, a computation stopped by ctx of another caller is done again.
+func (c *Cache) Do(ctx context.Context, signer, data string, compute func() (string, error)) (string, error) {
+	key := cacheKey{signer: signer, data: data}
+	for {
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			c.order.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return el.Value.(cacheEntry).val, nil
+		}
+		if running, ok := c.inflight[key]; ok {
+			c.stats.Hits++
+			c.mu.Unlock()
+			select {
+			case <-running.done:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			if isCanceled(running.err) && ctx.Err() == nil {
+				continue
+			}
+			return running.val, running.err
+		}
+		c.stats.Misses++
+		running := &call{done: make(chan struct{})}
+		c.inflight[key] = running
+		c.mu.Unlock()
+		return c.run(key, running, compute)
+	}
+}
+
+// run computes the value of key for the callers waiting on running,
+// a panic of compute is their error and goes on up the stack of this caller
+func (c *Cache) run(key cacheKey, running *call, compute func() (string, error)) (string, error) {
+	defer func() {
+		p := recover()
+		if p != nil {
+			running.err = fmt.Errorf("panicked: %v", p)
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if running.err == nil {
+			c.add(key, running.val)
+		}
+		c.mu.Unlock()
+		close(running.done)
+		if p != nil {
+			panic(p)
+		}
+	}()
+	running.val, running.err = compute()
+	return running.val, running.err
+}
+
+func (c *Cache) add(key cacheKey, val string) {
+	c.entries[key] = c.order.PushFront(cacheEntry{key: key, val: val})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cacheEntry).key)
+	}
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// WindowConfig tells when CombineWindows gives a window, the zero value gives one at the end like CombineResults
+type WindowConfig struct {
+	Count int           // a window is given when it has Count values, 0 means no limit
+	Every time.Duration // and every Every since the last one was given, 0 means never
+}
+
+// CombineWindows joins the values of every tumbling window like CombineResults does with all of them,
+// the values left are given when in is closed. Empty windows are not given.
+func CombineWindows(cfg WindowConfig) Stage[string, string] {
+	return func(ctx context.Context, in chan string, out chan string) error {
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if cfg.Every > 0 {
+			timer = time.NewTimer(cfg.Every)
+			defer timer.Stop()
+			tick = timer.C
+		}
+		var window []string
+		flush := func() error {
+			if timer != nil {
+				timer.Reset(cfg.Every)
+			}
+			if len(window) == 0 {
+				return nil
+			}
+			result := joinSorted(window)
+			window = nil
+			return send(ctx, out, result)
+		}
+
+		for {
+			select {
+			case val, ok := <-in:
+				if !ok {
+					return flush()
+				}
+				window = append(window, val)
+				if cfg.Count > 0 && len(window) >= cfg.Count {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			case <-tick:
+				if err := flush(); err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"fmt"
 	"hash/crc32"
 	"strconv"
-	"sync/atomic"
 	"time"
 )
 
@@ -20,26 +20,15 @@ var (
 	DataSignerSalt            = ""
 )
 
+// overheat takes the place of the CAS loop on dataSignerOverheat, the second caller waits instead of sleeping a second
+var overheat = NewSemaphore(1)
+
 var OverheatLock = func() {
-	for {
-		if swapped := atomic.CompareAndSwapUint32(&dataSignerOverheat, 0, 1); !swapped {
-			fmt.Println("OverheatLock happend")
-			time.Sleep(time.Second)
-		} else {
-			break
-		}
-	}
+	overheat.Acquire(context.Background())
 }
 
 var OverheatUnlock = func() {
-	for {
-		if swapped := atomic.CompareAndSwapUint32(&dataSignerOverheat, 1, 0); !swapped {
-			fmt.Println("OverheatUnlock happend")
-			time.Sleep(time.Second)
-		} else {
-			break
-		}
-	}
+	overheat.Release()
 }
 
 var DataSignerMd5 = func(data string) string {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"strconv"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Signers are the hash functions SignerConfig can name, the salt is added like in DataSignerCrc32.
+// md5 and crc32 call DataSignerMd5 and DataSignerCrc32, so they overheat and sleep the same way.
+var Signers = map[string]func(data string) string{
+	"md5": func(data string) string {
+		return DataSignerMd5(data)
+	},
+	"crc32": func(data string) string {
+		return DataSignerCrc32(data)
+	},
+	"sha1": func(data string) string {
+		return fmt.Sprintf("%x", sha1.Sum([]byte(data+DataSignerSalt)))
+	},
+	"sha256": func(data string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(data+DataSignerSalt)))
+	},
+	"crc32c": func(data string) string {
+		return strconv.FormatUint(uint64(crc32.Checksum([]byte(data+DataSignerSalt), castagnoli)), 10)
+	},
+	// fnv64a is the fast non-cryptographic one, like xxhash but in the standard library
+	"fnv64a": func(data string) string {
+		h := fnv.New64a()
+		h.Write([]byte(data + DataSignerSalt))
+		return strconv.FormatUint(h.Sum64(), 10)
+	},
+}
+
+// SignerConfig describes the hash stages
+type SignerConfig struct {
+	// SingleHash joins with ~ the hashes of the input, every one is a chain of signers applied from the left,
+	// {{"crc32"}, {"md5", "crc32"}} is crc32(data)~crc32(md5(data)). The chains go at once.
+	SingleHash [][]string
+	// MultiHash concatenates MultiHash(th+data) for th 0..Width-1, all of them go at once
+	MultiHash string
+	Width     int
+	Limits    Limits
+	FanOut    FanOutConfig
+}
+
+// DefaultSignerConfig is the signer of the task
+var DefaultSignerConfig = SignerConfig{
+	SingleHash: [][]string{{"crc32"}, {"md5", "crc32"}},
+	MultiHash:  "crc32",
+	Width:      6,
+	Limits:     defaultLimits,
+}
+
+func (c SignerConfig) Validate() error {
+	if len(c.SingleHash) == 0 {
+		return fmt.Errorf("no SingleHash signers")
+	}
+	for _, chain := range c.SingleHash {
+		if len(chain) == 0 {
+			return fmt.Errorf("empty SingleHash chain")
+		}
+		for _, name := range chain {
+			if _, ok := Signers[name]; !ok {
+				return fmt.Errorf("unknown signer %q", name)
+			}
+		}
+	}
+	if _, ok := Signers[c.MultiHash]; !ok {
+		return fmt.Errorf("unknown signer %q", c.MultiHash)
+	}
+	if c.Width < 1 {
+		return fmt.Errorf("invalid MultiHash width %d, must be greater than 0", c.Width)
+	}
+	return nil
+}
+
+// Stages builds SingleHash and MultiHash of the config
+func (c SignerConfig) Stages() (Stage[int, string], Stage[string, string], error) {
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return FanOut(c.FanOut, c.singleHash), FanOut(c.FanOut, c.multiHash), nil
+}
@@ -1,7 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -58,3 +69,981 @@ func TestByIlia(t *testing.T) {
 		t.Errorf("f3 have not collected inputs, recieved = %d", recieved)
 	}
 }
+
+// counter sends 0, 1, 2... until ctx is done
+func counter(ctx context.Context, in, out chan interface{}) error {
+	for n := 0; ; n++ {
+		if err := send[interface{}](ctx, out, n); err != nil {
+			return err
+		}
+	}
+}
+
+func TestPipelineContextError(t *testing.T) {
+	checkGoroutines(t)
+	errStop := errors.New("stop at 10")
+	var passed uint32
+	err := ExecutePipelineContext(context.Background(),
+		counter,
+		func(ctx context.Context, in, out chan interface{}) error {
+			for val := range in {
+				if val.(int) == 10 {
+					return errStop
+				}
+				if err := send(ctx, out, val); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			for range in {
+				atomic.AddUint32(&passed, 1)
+			}
+			return nil
+		},
+	)
+	if err != errStop {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, errStop)
+	}
+	if passed != 10 {
+		t.Errorf("wrong number of values passed\nGot: %d\nExpected: 10", passed)
+	}
+}
+
+func TestPipelineContextPanic(t *testing.T) {
+	checkGoroutines(t)
+	err := ExecutePipelineContext(context.Background(),
+		counter,
+		withContext(func(in, out chan interface{}) {
+			for val := range in {
+				out <- val.(string) // the ints of counter do not pass
+			}
+		}),
+	)
+	if err == nil || !strings.HasPrefix(err.Error(), "job 1 panicked") {
+		t.Errorf("expected the panic of job 1, got %v", err)
+	}
+}
+
+func TestPipelineContextCancel(t *testing.T) {
+	checkGoroutines(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := ExecutePipelineContext(ctx,
+		counter,
+		withContext(func(in, out chan interface{}) {
+			<-in // the rest of the values are drained
+		}),
+	)
+	if err != context.DeadlineExceeded {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, context.DeadlineExceeded)
+	}
+	if end := time.Since(start); end > time.Second {
+		t.Errorf("execition too long\nGot: %s\nExpected: <%s", end, time.Second)
+	}
+}
+
+// fastSigners drops the sleeps of the signers for the tests checking only the results
+func fastSigners(t *testing.T) {
+	signMd5, signCrc32 := DataSignerMd5, DataSignerCrc32
+	t.Cleanup(func() {
+		DataSignerMd5, DataSignerCrc32 = signMd5, signCrc32
+	})
+	withoutSleeps()
+}
+
+// the error of a job in the middle cancels the jobs after it while the ones before still run
+func TestPipelineContextErrorCancelsAll(t *testing.T) {
+	checkGoroutines(t)
+	errStop := errors.New("stop")
+	start := time.Now()
+	var cancelledAfter time.Duration
+	err := ExecutePipelineContext(context.Background(),
+		withContext(func(in, out chan interface{}) {
+			time.Sleep(200 * time.Millisecond)
+		}),
+		func(ctx context.Context, in, out chan interface{}) error {
+			return errStop
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			<-ctx.Done()
+			cancelledAfter = time.Since(start)
+			return nil
+		},
+	)
+	if err != errStop {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, errStop)
+	}
+	if cancelledAfter > 100*time.Millisecond {
+		t.Errorf("the last job cancelled too late\nGot: %s\nExpected: <%s", cancelledAfter, 100*time.Millisecond)
+	}
+}
+
+func TestStageThen(t *testing.T) {
+	checkGoroutines(t)
+	var double Stage[int, int] = func(ctx context.Context, in chan int, out chan int) error {
+		for val := range in {
+			if err := send(ctx, out, val*2); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var format Stage[int, string] = func(ctx context.Context, in chan int, out chan string) error {
+		for val := range in {
+			if err := send(ctx, out, "#"+strconv.Itoa(val)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	result, err := Run(context.Background(), Then(Then(double, double), format), 1, 2, 3)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, expected := strings.Join(result, " "), "#4 #8 #12"; got != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", got, expected)
+	}
+
+	errStop := errors.New("stop")
+	_, err = Run(context.Background(), Then(double, Stage[int, string](func(ctx context.Context, in chan int, out chan string) error {
+		<-in
+		return errStop
+	})), 1, 2, 3)
+	if err != errStop {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, errStop)
+	}
+}
+
+func TestStageSigner(t *testing.T) {
+	fastSigners(t)
+	result, err := Run(context.Background(), Then(Then(SingleHashStage, MultiHashStage), CombineResultsStage), 0, 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestUntypedWrongType(t *testing.T) {
+	checkGoroutines(t)
+	fastSigners(t)
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			return send[interface{}](ctx, out, "1")
+		},
+		withContext(SingleHash),
+	)
+	if err == nil || err.Error() != "job 1 panicked: wrong type string of the value 1" {
+		t.Errorf("expected the wrong type of job 1, got %v", err)
+	}
+}
+
+func TestFanOutOrdered(t *testing.T) {
+	checkGoroutines(t)
+	mu := &sync.Mutex{}
+	var running, maxRunning int
+	slow := func(ctx context.Context, val int) (int, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Duration(10-val) * 10 * time.Millisecond) // the first ones are the slowest
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return val, nil
+	}
+	values := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	result, err := Run(context.Background(), FanOut(FanOutConfig{Ordered: true, InFlight: 3}, slow), values...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, expected := fmt.Sprint(result), fmt.Sprint(values); got != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", got, expected)
+	}
+	if maxRunning > 3 {
+		t.Errorf("too many values in flight\nGot: %d\nExpected: <=3", maxRunning)
+	}
+
+	result, err = Run(context.Background(), FanOut(FanOutConfig{}, slow), values...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, expected := fmt.Sprint(result), "[9 8 7 6 5 4 3 2 1 0]"; got != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func TestFanOutSigner(t *testing.T) {
+	fastSigners(t)
+	ordered := FanOutConfig{Ordered: true, InFlight: 2}
+	result, err := Run(context.Background(), Then(NewSingleHash(ordered, defaultLimits), NewMultiHash(ordered, defaultLimits)), 1, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []string{
+		"4958044192186797981418233587017209679042592862002427381542",
+		"29568666068035183841425683795340791879727309630931025356555",
+	}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestLimiters(t *testing.T) {
+	sem := NewSemaphore(1)
+	if err := sem.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sem.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wrong error of the busy semaphore\nGot: %v\nExpected: %v", err, context.DeadlineExceeded)
+	}
+	sem.Release()
+
+	bucket := NewTokenBucket(100, 2)
+	start := time.Now()
+	for n := 0; n < 6; n++ {
+		if err := bucket.Acquire(context.Background()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	// 2 at once, then 4 more at 10ms each
+	if end := time.Since(start); end < 35*time.Millisecond || end > 500*time.Millisecond {
+		t.Errorf("wrong rate\nGot: %s\nExpected: about 40ms", end)
+	}
+
+	slow := NewTokenBucket(1, 1)
+	slow.Acquire(context.Background())
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := slow.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wrong error of the empty bucket\nGot: %v\nExpected: %v", err, context.DeadlineExceeded)
+	}
+
+	for _, c := range []struct {
+		rate  float64
+		burst int
+	}{{0, 1}, {-1, 1}, {math.NaN(), 1}, {1, 0}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("no panic for the rate %v and the burst %d", c.rate, c.burst)
+				}
+			}()
+			NewTokenBucket(c.rate, c.burst)
+		}()
+	}
+}
+
+func TestLimitsInStages(t *testing.T) {
+	checkGoroutines(t)
+	fastSigners(t)
+	mu := &sync.Mutex{}
+	var running, maxRunning int
+	crc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return crc32(data)
+	}
+
+	limits := Limits{Md5: NewSemaphore(1), Crc32: NewSemaphore(2)}
+	result, err := Run(context.Background(), Then(NewSingleHash(FanOutConfig{}, limits), NewMultiHash(FanOutConfig{}, limits)), 0, 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(result) != 3 {
+		t.Errorf("wrong number of results\nGot: %d\nExpected: 3", len(result))
+	}
+	if maxRunning > 2 {
+		t.Errorf("too many DataSignerCrc32 at once\nGot: %d\nExpected: <=2", maxRunning)
+	}
+
+	// the token bucket spaces out the calls of the stage after the burst
+	start := time.Now()
+	result, err = Run(context.Background(), NewMultiHash(FanOutConfig{}, Limits{Crc32: NewTokenBucket(200, 2)}), "a", "b")
+	if err != nil || len(result) != 2 {
+		t.Fatalf("unexpected result %v, error %v", result, err)
+	}
+	if end := time.Since(start); end < 45*time.Millisecond {
+		t.Errorf("too fast for 12 calls at 200 a second with a burst of 2\nGot: %s\nExpected: >=50ms", end)
+	}
+	vars, err := parseArgs([]string{"-crc32-rate", "200", "-crc32-burst", "2"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := vars.config.Limits.Crc32.(*TokenBucket); !ok {
+		t.Errorf("expected a token bucket for -crc32-rate, got %T", vars.config.Limits.Crc32)
+	}
+
+	// the stage waiting for the limiter stops with ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	blocked := NewSemaphore(1)
+	blocked.Acquire(context.Background())
+	_, err = Run(ctx, NewMultiHash(FanOutConfig{}, Limits{Crc32: blocked}), "x")
+	if err != context.DeadlineExceeded {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCacheSigner(t *testing.T) {
+	fastSigners(t)
+	var md5Calls, crc32Calls uint32
+	md5, crc32 := DataSignerMd5, DataSignerCrc32
+	DataSignerMd5 = func(data string) string {
+		atomic.AddUint32(&md5Calls, 1)
+		return md5(data)
+	}
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddUint32(&crc32Calls, 1)
+		time.Sleep(10 * time.Millisecond) // the two 1 are hashed at once
+		return crc32(data)
+	}
+
+	cache := NewCache(100)
+	limits := Limits{Md5: NewSemaphore(1), Cache: cache}
+	stage := Then(Then(NewSingleHash(FanOutConfig{}, limits), NewMultiHash(FanOutConfig{}, limits)), CombineResultsStage)
+	result, err := Run(context.Background(), stage, 0, 1, 1, 2, 3, 5, 8)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "1173136728138862632818075107442090076184424490584241521304_1696913515191343735512658979631549563179965036907783101867_27225454331033649287118297354036464389062965355426795162684_29568666068035183841425683795340791879727309630931025356555_3994492081516972096677631278379039212655368881548151736_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	// 6 different inputs: 6 md5, 6*2 crc32 in SingleHash and 6*6 in MultiHash
+	if md5Calls != 6 || crc32Calls != 48 {
+		t.Errorf("wrong number of hash-func calls\nGot: md5 %d, crc32 %d\nExpected: md5 6, crc32 48", md5Calls, crc32Calls)
+	}
+	if stats := cache.Stats(); stats != (CacheStats{Hits: 9, Misses: 54}) {
+		t.Errorf("wrong cache stats\nGot: %+v\nExpected: {Hits:9 Misses:54}", stats)
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	cache := NewCache(2)
+	computed := ""
+	get := func(data string) {
+		cache.Do(context.Background(), "id", data, func() (string, error) {
+			computed += data
+			return data, nil
+		})
+	}
+	for _, data := range []string{"a", "b", "a", "c", "b", "a"} {
+		get(data)
+	}
+	// c pushes b out, b pushes a out
+	if computed != "abcba" {
+		t.Errorf("wrong computations\nGot: %v\nExpected: abcba", computed)
+	}
+	if stats := cache.Stats(); stats != (CacheStats{Hits: 1, Misses: 5}) {
+		t.Errorf("wrong cache stats\nGot: %+v\nExpected: {Hits:1 Misses:5}", stats)
+	}
+
+	_, err := cache.Do(context.Background(), "id", "err", func() (string, error) {
+		return "", errors.New("failed")
+	})
+	if val, _ := cache.Do(context.Background(), "id", "err", func() (string, error) {
+		return "ok", nil
+	}); err == nil || val != "ok" {
+		t.Errorf("the error is kept in the cache")
+	}
+
+	// the waiters get the panic as an error, the key is computed again after it
+	release := make(chan struct{})
+	waited := make(chan error)
+	go func() {
+		defer func() {
+			recover()
+		}()
+		cache.Do(context.Background(), "id", "panic", func() (string, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+	go func() {
+		for cache.Stats().Misses < 8 {
+			time.Sleep(time.Millisecond)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := cache.Do(ctx, "id", "panic", func() (string, error) {
+			return "", errors.New("computed by the waiter")
+		})
+		waited <- err
+	}()
+	for cache.Stats().Hits < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-waited; err == nil || !strings.Contains(err.Error(), "panicked: boom") {
+		t.Errorf("wrong error of the waiter\nGot: %v\nExpected: panicked: boom", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if val, err := cache.Do(ctx, "id", "panic", func() (string, error) {
+		return "ok", nil
+	}); err != nil || val != "ok" {
+		t.Errorf("the key is not computed again after a panic\nGot: %q, %v\nExpected: ok", val, err)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	fastSigners(t)
+	report := &Report{}
+	exported := NewExpvarObserver(fmt.Sprint("signer_test_", time.Now().UnixNano())) // published once per process
+	var mu sync.Mutex
+	var kinds []EventKind
+	recorder := ObserverFunc(func(e Event) {
+		if e.Stage == "CombineResults" {
+			mu.Lock()
+			kinds = append(kinds, e.Kind)
+			mu.Unlock()
+		}
+	})
+	obs := Observers(report, exported, recorder)
+	stage := Then(Then(
+		ObservedUnordered("SingleHash", obs, NewSingleHash(FanOutConfig{}, defaultLimits)),
+		ObservedUnordered("MultiHash", obs, NewMultiHash(FanOutConfig{}, defaultLimits))),
+		Observed("CombineResults", obs, CombineResultsStage))
+	result, err := Run(context.Background(), stage, 0, 1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	expectedKinds := []EventKind{StageStart, ItemIn, ItemIn, ItemOut, StageEnd}
+	if fmt.Sprint(kinds) != fmt.Sprint(expectedKinds) {
+		t.Errorf("events not match\nGot: %v\nExpected: %v", kinds, expectedKinds)
+	}
+	stats := report.Stats()
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 stages, got %v", stats)
+	}
+	outs := map[string]int{"SingleHash": 2, "MultiHash": 2, "CombineResults": 1}
+	for _, s := range stats {
+		if s.In != 2 || s.Out != outs[s.Name] || s.End.Before(s.Start) || s.Err != nil {
+			t.Errorf("wrong stats of %s: %+v", s.Name, s)
+		}
+		// the values of the fan-out stages are paired with the oldest taken ones, not their own
+		if unordered := s.Name != "CombineResults"; s.Unordered != unordered || unordered && s.MaxLatency != 0 {
+			t.Errorf("wrong latency of %s: %+v", s.Name, s)
+		}
+	}
+	text := &strings.Builder{}
+	if _, err := report.WriteTo(text); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !strings.HasPrefix(text.String(), "stage ") || !strings.Contains(text.String(), "\nMultiHash ") ||
+		!strings.Contains(text.String(), "\nbottleneck: ") {
+		t.Errorf("wrong report:\n%s", text)
+	}
+	for _, line := range strings.Split(text.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 9 && fields[0] == "MultiHash" && fields[7] != "-" {
+			t.Errorf("max latency of an unordered stage in the report:\n%s", text)
+		}
+	}
+
+	vars := exported.vars.Get("MultiHash").(*expvar.Map)
+	if vars.Get("items_in").String() != "2" || vars.Get("items_out").String() != "2" || vars.Get("running").String() != "0" {
+		t.Errorf("wrong expvar: %s", vars)
+	}
+}
+
+func TestObserveJobs(t *testing.T) {
+	checkGoroutines(t)
+	report := &Report{}
+	fail := errors.New("fail")
+	jobs := ObserveJobs(report, counter, func(ctx context.Context, in, out chan interface{}) error {
+		<-in
+		return fail
+	})
+	if err := ExecutePipelineContext(context.Background(), jobs...); !errors.Is(err, fail) {
+		t.Fatalf("expected %v, got %v", fail, err)
+	}
+	stats := report.Stats() // the order of the first events
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	if len(stats) != 2 || stats[0].Name != "job 0" || stats[1].Name != "job 1" || !errors.Is(stats[1].Err, fail) {
+		t.Errorf("wrong stats %+v", stats)
+	}
+}
+
+// testdata/default.golden has the input, SingleHash and MultiHash of the signer of the task on every line
+func TestSignerGolden(t *testing.T) {
+	fastSigners(t)
+	golden, err := os.ReadFile("testdata/default.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, multi, err := DefaultSignerConfig.Stages()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(golden)), "\n") {
+		fields := strings.Fields(line)
+		value, _ := strconv.Atoi(fields[0])
+		result, err := Run(context.Background(), Then(single, multi), value)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		singleResult, _ := Run(context.Background(), single, value)
+		if singleResult[0] != fields[1] || result[0] != fields[2] {
+			t.Errorf("results of %d not match\nGot: %s %s\nExpected: %s %s", value, singleResult[0], result[0], fields[1], fields[2])
+		}
+	}
+}
+
+func TestSignerConfig(t *testing.T) {
+	fastSigners(t)
+	cfg := SignerConfig{
+		SingleHash: [][]string{{"sha1"}, {"sha256", "crc32c"}, {"fnv64a"}},
+		MultiHash:  "crc32c",
+		Width:      12,
+	}
+	single, multi, err := cfg.Stages()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	result, err := Run(context.Background(), single, 7)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := Signers["sha1"]("7") + "~" + Signers["crc32c"](Signers["sha256"]("7")) + "~" + Signers["fnv64a"]("7")
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	result, err = Run(context.Background(), multi, "x")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected = ""
+	for th := 0; th < 12; th++ {
+		expected += Signers["crc32c"](strconv.Itoa(th) + "x")
+	}
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	for _, wrong := range []SignerConfig{
+		{MultiHash: "crc32", Width: 6},
+		{SingleHash: [][]string{{"md4"}}, MultiHash: "crc32", Width: 6},
+		{SingleHash: [][]string{{"md5"}}, MultiHash: "crc32", Width: 0},
+	} {
+		if _, _, err := wrong.Stages(); err == nil {
+			t.Errorf("expected an error for %+v", wrong)
+		}
+	}
+}
+
+func TestCombineWindows(t *testing.T) {
+	checkGoroutines(t)
+	result, err := Run(context.Background(), CombineWindows(WindowConfig{Count: 3}), "c", "a", "b", "e", "d", "f", "g")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []string{"a_b_c", "d_e_f", "g"}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	// the first window is given before in is closed
+	every := 20 * time.Millisecond
+	slow := func(ctx context.Context, in, out chan string) error {
+		send(ctx, out, "c")
+		send(ctx, out, "a")
+		time.Sleep(3 * every)
+		return send(ctx, out, "b")
+	}
+	result, err = Run(context.Background(), Then(slow, CombineWindows(WindowConfig{Every: every})))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected = []string{"a_c", "b"}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	result, err = Run(context.Background(), CombineWindows(WindowConfig{}), "b", "a")
+	if err != nil || fmt.Sprint(result) != "[a_b]" {
+		t.Errorf("results not match\nGot: %v %v\nExpected: [a_b]", result, err)
+	}
+}
+
+func TestSignerCommand(t *testing.T) {
+	checkGoroutines(t)
+	fastSigners(t)
+	vars, err := parseArgs([]string{"-ordered", "-window", "2", "-"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out := &strings.Builder{}
+	vars.in, vars.out = strings.NewReader("0\n\n1\r\n2\n"), out
+	if err := runSigner(context.Background(), vars); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// the combined results go on at once with the next values
+	var items, combined []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.HasPrefix(line, "combined\t") {
+			combined = append(combined, line)
+		} else {
+			items = append(items, line)
+		}
+	}
+	expectedItems := []string{
+		"0\t4108050209~502633748\t29568666068035183841425683795340791879727309630931025356555",
+		"1\t2212294583~709660146\t4958044192186797981418233587017209679042592862002427381542",
+		"2\t450215437~1933333237\t27225454331033649287118297354036464389062965355426795162684",
+	}
+	expectedCombined := []string{
+		"combined\t29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542",
+		"combined\t27225454331033649287118297354036464389062965355426795162684",
+	}
+	if fmt.Sprint(items, combined) != fmt.Sprint(expectedItems, expectedCombined) {
+		t.Errorf("results not match\nGot:\n%s\nExpected:\n%v\n%v", out, expectedItems, expectedCombined)
+	}
+
+	vars, err = parseArgs([]string{"-json", "-single", "sha1", "-width", "1", "-multi", "crc32c"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out.Reset()
+	vars.in, vars.out = strings.NewReader("hello world\n"), out
+	if err := runSigner(context.Background(), vars); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	single := Signers["sha1"]("hello world")
+	multi := Signers["crc32c"]("0" + single)
+	expected := `{"input":"hello world","single_hash":"` + single + `","multi_hash":"` + multi + `"}` + "\n" +
+		`{"combined":"` + multi + `"}` + "\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot:\n%s\nExpected:\n%s", out, expected)
+	}
+
+	for _, args := range [][]string{{"-single", "md4"}, {"-width", "0"}, {"-window", "-1"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+	vars, _ = parseArgs([]string{"testdata/missing"})
+	vars.out = out
+	if err := runSigner(context.Background(), vars); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v, got %v", os.ErrNotExist, err)
+	}
+}
+
+// goroutines are the stacks of the running goroutines by their ids
+func goroutines() map[string]string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	all := map[string]string{}
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		all[strings.Fields(stack)[1]] = stack // goroutine 7 [running]:
+	}
+	return all
+}
+
+// leaked waits up to timeout for the goroutines not in before to end and gives the stacks of the ones left
+func leaked(before map[string]string, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		var left []string
+		for id, stack := range goroutines() {
+			if _, ok := before[id]; !ok {
+				left = append(left, stack)
+			}
+		}
+		if len(left) == 0 || time.Now().After(deadline) {
+			return left
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// checkGoroutines fails the test if the goroutines it started are still there at its end
+func checkGoroutines(t *testing.T) {
+	before := goroutines()
+	t.Cleanup(func() {
+		if left := leaked(before, time.Second); len(left) > 0 {
+			t.Errorf("%d goroutines leaked:\n%s", len(left), strings.Join(left, "\n\n"))
+		}
+	})
+}
+
+func TestLeaked(t *testing.T) {
+	before := goroutines()
+	block := make(chan struct{})
+	go func() {
+		<-block
+	}()
+	if left := leaked(before, 10*time.Millisecond); len(left) != 1 || !strings.Contains(left[0], "TestLeaked") {
+		t.Errorf("expected the blocked goroutine, got %v", left)
+	}
+	close(block)
+	if left := leaked(before, time.Second); len(left) != 0 {
+		t.Errorf("expected no goroutines, got %v", left)
+	}
+
+	// a job stopping early does not leave the one before it blocked on sending
+	checkGoroutines(t)
+	ExecutePipeline(
+		func(in, out chan interface{}) {
+			for n := 0; n < 100; n++ {
+				out <- n
+			}
+		},
+		func(in, out chan interface{}) {
+			<-in
+		},
+	)
+}
+
+func TestWatchdog(t *testing.T) {
+	checkGoroutines(t)
+	dumps := make(chan string, 1)
+	watchdog := NewWatchdog(20*time.Millisecond, func(dump string) {
+		dumps <- dump
+	})
+	defer watchdog.Stop()
+
+	release := make(chan struct{})
+	var stuck Stage[int, int] = func(ctx context.Context, in, out chan int) error {
+		<-in
+		<-release
+		return nil
+	}
+	done := make(chan error)
+	go func() {
+		_, err := Run(context.Background(), Observed("stuck", watchdog, stuck), 1, 2, 3, 4)
+		done <- err
+	}()
+
+	dump := <-dumps
+	if !strings.HasPrefix(dump, "no value moved for ") || !strings.Contains(dump, "stuck: running, ") ||
+		!strings.Contains(dump, "in chan 1/1, out chan 0/1, not reading") {
+		t.Errorf("wrong dump:\n%s", dump)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	select {
+	case dump := <-dumps:
+		t.Errorf("unexpected dump after the end:\n%s", dump)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if dump := watchdog.Dump(); !strings.Contains(dump, "stuck: done, ") || !strings.Contains(dump, " in, 0 out") {
+		t.Errorf("wrong dump:\n%s", dump)
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	checkGoroutines(t)
+	var sent int32
+	var producer Stage[int, int] = func(ctx context.Context, in, out chan int) error {
+		for n := 0; n < 10; n++ {
+			if err := send(ctx, out, n); err != nil {
+				return err
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+		return nil
+	}
+	release := make(chan struct{})
+	var consumer Stage[int, int] = func(ctx context.Context, in, out chan int) error {
+		<-release
+		for val := range in {
+			if err := send(ctx, out, val); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the channel between the stages holds 1 value, the buffer 4 more and one is in its hands
+	buffer := NewBuffer(BufferConfig{Size: 4})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	result, err := Run(context.Background(), Then(Buffered(buffer, producer), consumer))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if fmt.Sprint(result) != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Errorf("results not match\nGot: %v\nExpected: [0 1 2 3 4 5 6 7 8 9]", result)
+	}
+	if got := atomic.LoadInt32(&sent); got != 10 {
+		t.Errorf("wrong number of values sent\nGot: %d\nExpected: 10", got)
+	}
+	stats := buffer.Stats()
+	if stats.Size != 4 || stats.Values != 10 || stats.Grows != 0 || stats.FullTime < 30*time.Millisecond {
+		t.Errorf("wrong stats %+v", stats)
+	}
+
+	// the values come in bursts, the next stage is faster and waits for them
+	var bursts Stage[int, int] = func(ctx context.Context, in, out chan int) error {
+		for n := 0; n < 128; n++ {
+			if n%16 == 0 {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err := send(ctx, out, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var slower Stage[int, int] = func(ctx context.Context, in, out chan int) error {
+		for val := range in {
+			for start := time.Now(); time.Since(start) < 50*time.Microsecond; {
+			}
+			if err := send(ctx, out, val); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	buffer = NewBuffer(BufferConfig{Adaptive: true, Max: 8})
+	result, err = Run(context.Background(), Then(Buffered(buffer, bursts), slower))
+	if err != nil || len(result) != 128 {
+		t.Fatalf("unexpected result %d values, error %v", len(result), err)
+	}
+	stats = buffer.Stats()
+	if stats.Grows == 0 || stats.Size <= 1 || stats.Size > 8 || stats.EmptyTime <= stats.FullTime {
+		t.Errorf("wrong stats %+v", stats)
+	}
+}
+
+func TestSignerCommandCancel(t *testing.T) {
+	fastSigners(t)
+	vars, err := parseArgs(nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	stdin, writer := io.Pipe() // nothing is written, the read blocks
+	defer writer.Close()
+	vars.in, vars.out = stdin, &strings.Builder{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := runSigner(ctx, vars); err != context.DeadlineExceeded {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, context.DeadlineExceeded)
+	}
+	if end := time.Since(start); end > time.Second {
+		t.Errorf("execition too long\nGot: %s\nExpected: <%s", end, time.Second)
+	}
+}
+
+func TestFanOutPanic(t *testing.T) {
+	checkGoroutines(t)
+	_, err := Run(context.Background(), FanOut(FanOutConfig{}, func(ctx context.Context, val int) (int, error) {
+		panic("boom")
+	}), 1)
+	if err == nil || err.Error() != "panicked: boom" {
+		t.Errorf("wrong error\nGot: %v\nExpected: panicked: boom", err)
+	}
+
+	// the goroutines of the chains and of th recover too
+	Signers["boom"] = func(data string) string {
+		panic("boom " + data)
+	}
+	defer delete(Signers, "boom")
+	cfg := SignerConfig{SingleHash: [][]string{{"boom"}}, MultiHash: "boom", Width: 2}
+	single, multi, err := cfg.Stages()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := Run(context.Background(), single, 1); err == nil || err.Error() != "panicked: boom 1" {
+		t.Errorf("wrong error\nGot: %v\nExpected: panicked: boom 1", err)
+	}
+	if _, err := Run(context.Background(), multi, "x"); err == nil || !strings.Contains(err.Error(), "panicked: boom 0x") {
+		t.Errorf("wrong error\nGot: %v\nExpected: panicked: boom 0x", err)
+	}
+}
+
+func TestExecutePipelineBuffered(t *testing.T) {
+	checkGoroutines(t)
+	var sent int32
+	var sentBeforeRead int32
+	producer := func(ctx context.Context, in, out chan interface{}) error {
+		for n := 0; n < 10; n++ {
+			if err := send[interface{}](ctx, out, n); err != nil {
+				return err
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+		return nil
+	}
+	received := 0
+	consumer := func(ctx context.Context, in, out chan interface{}) error {
+		time.Sleep(50 * time.Millisecond)
+		sentBeforeRead = atomic.LoadInt32(&sent)
+		for range in {
+			received++
+		}
+		return nil
+	}
+
+	// the job gets 4 values ahead in its buffer and 1 in the channel after it
+	buffer := NewBuffer(BufferConfig{Size: 4})
+	err := ExecutePipelineBuffered(context.Background(), BufferedJob{Job: producer, Buffer: buffer}, BufferedJob{Job: consumer})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if sentBeforeRead != 5 || received != 10 {
+		t.Errorf("wrong number of values\nGot: %d sent before the read, %d received\nExpected: 5, 10", sentBeforeRead, received)
+	}
+	if stats := buffer.Stats(); stats.Values != 10 || stats.FullTime < 30*time.Millisecond {
+		t.Errorf("wrong stats %+v", stats)
+	}
+
+	atomic.StoreInt32(&sent, 0)
+	received = 0
+	if err := ExecutePipelineBuffered(context.Background(), BufferedJob{Job: producer}, BufferedJob{Job: consumer}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if sentBeforeRead != 1 || received != 10 {
+		t.Errorf("wrong number of values\nGot: %d sent before the read, %d received\nExpected: 1, 10", sentBeforeRead, received)
+	}
+
+	vars, err := parseArgs([]string{"-single-buffer", "8", "-multi-adaptive"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := map[string]BufferConfig{"SingleHash": {Size: 8}, "MultiHash": {Adaptive: true}}
+	if fmt.Sprint(vars.buffers) != fmt.Sprint(expected) {
+		t.Errorf("wrong buffers\nGot: %v\nExpected: %v", vars.buffers, expected)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limiter lets a signer call through when there are resources for it, Acquire blocks until then
+type Limiter interface {
+	Acquire(ctx context.Context) error
+	Release()
+}
+
+// Semaphore lets at most cap of it calls run at once
+type Semaphore chan struct{}
+
+func NewSemaphore(n int) Semaphore {
+	return make(Semaphore, n)
+}
+
+func (s Semaphore) Acquire(ctx context.Context) error {
+	return send(ctx, s, struct{}{})
+}
+
+func (s Semaphore) Release() {
+	<-s
+}
+
+// TokenBucket lets rate calls a second through, up to burst of them at once after a pause
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket panics on a rate not above 0 or a burst below 1, the bucket would never let a call through
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if !(rate > 0) || burst < 1 {
+		panic(fmt.Sprintf("invalid token bucket rate %v and burst %d, must be greater than 0", rate, burst))
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *TokenBucket) Acquire(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Release does nothing, the tokens come back with time
+func (b *TokenBucket) Release() {}
+
+// Limits guard the signers in the hash stages, nil lets every call through
+type Limits struct {
+	Md5   Limiter
+	Crc32 Limiter
+	Cache *Cache // shares the results of the same calls, nil computes all of them
+}
+
+func limited(ctx context.Context, limiter Limiter, sign func(string) string, data string) (string, error) {
+	if limiter == nil {
+		return sign(data), nil
+	}
+	if err := limiter.Acquire(ctx); err != nil {
+		return "", err
+	}
+	defer limiter.Release()
+	return sign(data), nil
+}
+
+// limiter is the one of the signer, the others have no limits
+func (l Limits) limiter(name string) Limiter {
+	switch name {
+	case "md5":
+		return l.Md5
+	case "crc32":
+		return l.Crc32
+	}
+	return nil
+}
+
+// sign calls the signer of Signers by its name, it asks the cache before waiting for the limiter
+func (l Limits) sign(ctx context.Context, name string, data string) (string, error) {
+	sign, limiter := Signers[name], l.limiter(name)
+	if l.Cache == nil {
+		return limited(ctx, limiter, sign, data)
+	}
+	return l.Cache.Do(ctx, name, data, func() (string, error) {
+		return limited(ctx, limiter, sign, data)
+	})
+}
@@ -0,0 +1,344 @@
+// signer reads lines from the files or stdin and prints their SingleHash, MultiHash and the combined results:
+//
+//	seq 0 6 | signer -fast -window 7 -json
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signed is one input line with its hashes
+type signed struct {
+	Input      string `json:"input"`
+	SingleHash string `json:"single_hash"`
+	MultiHash  string `json:"multi_hash"`
+}
+
+type signerVars struct {
+	config  SignerConfig
+	window  WindowConfig
+	json    bool
+	files   []string // - or none is stdin
+	report  bool     // print the stats of the stages to stderr at the end
+	stall   time.Duration
+	buffers map[string]BufferConfig // of the output of SingleHash and MultiHash
+	fast    bool
+	verbose bool
+	in      io.Reader
+	out     io.Writer
+	errOut  io.Writer
+}
+
+// chains is -single, the chains are split by commas and the signers of a chain by +
+type chains [][]string
+
+func (c *chains) String() string {
+	list := make([]string, 0, len(*c))
+	for _, chain := range *c {
+		list = append(list, strings.Join(chain, "+"))
+	}
+	return strings.Join(list, ",")
+}
+
+func (c *chains) Set(value string) error {
+	*c = nil
+	for _, chain := range strings.Split(value, ",") {
+		*c = append(*c, strings.Split(chain, "+"))
+	}
+	return nil
+}
+
+func parseArgs(args []string) (*signerVars, error) {
+	vars := &signerVars{config: DefaultSignerConfig, buffers: map[string]BufferConfig{}}
+	c := &vars.config
+	fs := flag.NewFlagSet("signer", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: signer [flags] [file...]\n\nsigns every line of the files or stdin, an integer is signed like in the task\n\nflags:")
+		fs.PrintDefaults()
+	}
+	fs.Var((*chains)(&c.SingleHash), "single", "SingleHash `chains` of md5, sha1, sha256, crc32, crc32c or fnv64a, crc32,md5+crc32 is crc32(data)~crc32(md5(data))")
+	fs.StringVar(&c.MultiHash, "multi", c.MultiHash, "MultiHash `signer`")
+	fs.IntVar(&c.Width, "width", c.Width, "MultiHash hashes of a value")
+	fs.BoolVar(&c.FanOut.Ordered, "ordered", false, "print the values in the input order")
+	fs.IntVar(&c.FanOut.InFlight, "inflight", 0, "sign up to `n` values at once, 0 means no limit")
+	var singleBuffer, multiBuffer BufferConfig
+	fs.IntVar(&singleBuffer.Size, "single-buffer", 0, "let SingleHash sign `n` values ahead of MultiHash")
+	fs.BoolVar(&singleBuffer.Adaptive, "single-adaptive", false, "grow the -single-buffer when MultiHash waits for the values more than SingleHash waits for it")
+	fs.IntVar(&multiBuffer.Size, "multi-buffer", 0, "let MultiHash sign `n` values ahead of CombineResults")
+	fs.BoolVar(&multiBuffer.Adaptive, "multi-adaptive", false, "grow the -multi-buffer when CombineResults waits for the values more than MultiHash waits for it")
+	crc32Rate := fs.Float64("crc32-rate", 0, "call DataSignerCrc32 at most `n` times a second, 0 means no limit")
+	crc32Burst := fs.Int("crc32-burst", 1, "calls of DataSignerCrc32 at once after a pause with -crc32-rate")
+	cacheSize := fs.Int("cache", 0, "keep up to `n` hashes to sign the same values once")
+	fs.IntVar(&vars.window.Count, "window", 0, "print the combined result of every `n` values")
+	fs.DurationVar(&vars.window.Every, "every", 0, "print the combined result of the values of every `duration`")
+	fs.BoolVar(&vars.json, "json", false, "print JSON lines instead of text")
+	fs.BoolVar(&vars.report, "report", false, "print the stats of the stages to stderr at the end")
+	fs.DurationVar(&vars.stall, "stall", 0, "print the state of the stages to stderr when no value moves for `duration`")
+	fs.BoolVar(&vars.fast, "fast", false, "skip the sleeps of DataSignerMd5 and DataSignerCrc32")
+	fs.BoolVar(&vars.verbose, "v", false, "print the values going through the stages to stderr")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if *crc32Rate < 0 || *crc32Burst < 1 {
+		return nil, fmt.Errorf("invalid -crc32-rate %v or -crc32-burst %d", *crc32Rate, *crc32Burst)
+	}
+	if *crc32Rate > 0 {
+		c.Limits.Crc32 = NewTokenBucket(*crc32Rate, *crc32Burst)
+	}
+	if *cacheSize > 0 {
+		c.Limits.Cache = NewCache(*cacheSize)
+	}
+	if vars.window.Count < 0 || vars.window.Every < 0 {
+		return nil, fmt.Errorf("invalid window, must not be negative")
+	}
+	for name, buffer := range map[string]BufferConfig{"SingleHash": singleBuffer, "MultiHash": multiBuffer} {
+		if buffer.Size < 0 {
+			return nil, fmt.Errorf("invalid buffer %d of %s, must not be negative", buffer.Size, name)
+		}
+		if buffer.Size > 0 || buffer.Adaptive {
+			vars.buffers[name] = buffer
+		}
+	}
+	if vars.stall < 0 {
+		return nil, fmt.Errorf("invalid stall %v, must not be negative", vars.stall)
+	}
+	vars.files = fs.Args()
+	return vars, nil
+}
+
+// lineWriter prints the records of two stages at once
+type lineWriter struct {
+	mu   sync.Mutex
+	out  io.Writer
+	json bool
+}
+
+func (w *lineWriter) write(text string, record interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.json {
+		return json.NewEncoder(w.out).Encode(record)
+	}
+	_, err := fmt.Fprintln(w.out, text)
+	return err
+}
+
+// readLines sends the lines of the files, the empty ones are skipped
+func readLines(vars *signerVars) Stage[struct{}, signed] {
+	return func(ctx context.Context, in chan struct{}, out chan signed) error {
+		files := vars.files
+		if len(files) == 0 {
+			files = []string{"-"}
+		}
+		for _, name := range files {
+			if err := readFile(ctx, name, vars.in, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func readFile(ctx context.Context, name string, stdin io.Reader, out chan signed) error {
+	r := stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	// a read of stdin does not stop with ctx, so the lines are read in a goroutine left behind on ^C
+	lines := make(chan string)
+	var scanErr error // set before lines is closed
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, MaxLineLen)
+		for scanner.Scan() {
+			line := strings.TrimSuffix(scanner.Text(), "\r")
+			if line == "" {
+				continue
+			}
+			if send(ctx, lines, line) != nil {
+				return
+			}
+		}
+		scanErr = scanner.Err()
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if scanErr != nil && ctx.Err() == nil {
+					return fmt.Errorf("%s: %w", name, scanErr)
+				}
+				return ctx.Err()
+			}
+			if err := send(ctx, out, signed{Input: line}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// MaxLineLen is the longest input line
+const MaxLineLen = 1 << 20
+
+// signerStage reads, signs and prints the values and gives nothing
+func signerStage(vars *signerVars, w *lineWriter, obs Observer, buffers map[string]*Buffer) Stage[struct{}, struct{}] {
+	c := vars.config
+	single := FanOut(c.FanOut, func(ctx context.Context, s signed) (signed, error) {
+		var err error
+		s.SingleHash, err = c.singleHashOf(ctx, s.Input)
+		return s, err
+	})
+	multi := FanOut(c.FanOut, func(ctx context.Context, s signed) (signed, error) {
+		var err error
+		s.MultiHash, err = c.multiHash(ctx, s.SingleHash)
+		return s, err
+	})
+	var printed Stage[signed, string] = func(ctx context.Context, in chan signed, out chan string) error {
+		for s := range in {
+			if err := w.write(s.Input+"\t"+s.SingleHash+"\t"+s.MultiHash, s); err != nil {
+				return err
+			}
+			if err := send(ctx, out, s.MultiHash); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	combine := CombineWindows(vars.window)
+	if obs != nil {
+		observed := ObservedUnordered[signed, signed]
+		if c.FanOut.Ordered {
+			observed = Observed[signed, signed]
+		}
+		single = observed("SingleHash", obs, single)
+		multi = observed("MultiHash", obs, multi)
+		combine = Observed("CombineResults", obs, combine)
+	}
+	if cfg, ok := vars.buffers["SingleHash"]; ok {
+		buffers["SingleHash"] = NewBuffer(cfg)
+		single = Buffered(buffers["SingleHash"], single)
+	}
+	if cfg, ok := vars.buffers["MultiHash"]; ok {
+		buffers["MultiHash"] = NewBuffer(cfg)
+		multi = Buffered(buffers["MultiHash"], multi)
+	}
+	var combined Stage[string, struct{}] = func(ctx context.Context, in chan string, out chan struct{}) error {
+		for result := range in {
+			record := struct {
+				Combined string `json:"combined"`
+			}{result}
+			if err := w.write("combined\t"+result, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return Then(Then(Then(Then(Then(readLines(vars), single), multi), printed), combine), combined)
+}
+
+// runSigner prints a line for every input value and the combined results,
+// a text line is the input, SingleHash and MultiHash split by tabs or combined and the result
+func runSigner(ctx context.Context, vars *signerVars) error {
+	w := &lineWriter{out: vars.out, json: vars.json}
+	var observers []Observer
+	var report *Report
+	if vars.report {
+		report = &Report{}
+		observers = append(observers, report)
+	}
+	if vars.stall > 0 {
+		watchdog := NewWatchdog(vars.stall, func(dump string) {
+			fmt.Fprint(vars.errOut, dump)
+		})
+		defer watchdog.Stop()
+		observers = append(observers, watchdog)
+	}
+	var obs Observer
+	if len(observers) > 0 {
+		obs = Observers(observers...)
+	}
+	buffers := map[string]*Buffer{}
+	err := runStage(ctx, signerStage(vars, w, obs, buffers), nil, nil)
+	if report != nil {
+		report.WriteTo(vars.errOut)
+		for _, name := range []string{"SingleHash", "MultiHash"} {
+			if b, ok := buffers[name]; ok {
+				stats := b.Stats()
+				fmt.Fprintf(vars.errOut, "buffer of %s: size %d, grown %d times, full %v, empty %v\n", name,
+					stats.Size, stats.Grows, stats.FullTime.Round(time.Microsecond), stats.EmptyTime.Round(time.Microsecond))
+			}
+		}
+	}
+	return err
+}
+
+// withoutSleeps makes DataSignerMd5 and DataSignerCrc32 as fast as the hashes they compute
+func withoutSleeps() {
+	DataSignerMd5 = func(data string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(data+DataSignerSalt)))
+	}
+	DataSignerCrc32 = func(data string) string {
+		return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(data+DataSignerSalt))), 10)
+	}
+}
+
+func main() {
+	vars, err := parseArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	vars.in, vars.out, vars.errOut = os.Stdin, os.Stdout, os.Stderr
+	traceOut = io.Discard
+	if vars.verbose {
+		traceOut = os.Stderr
+	}
+	if vars.fast {
+		withoutSleeps()
+	}
+
+	// ^C stops the pipeline, the values being signed are dropped, a second ^C kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	err = runSigner(ctx, vars)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "signer: interrupted")
+		os.Exit(130)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "signer:", err)
+		os.Exit(1)
+	}
+}
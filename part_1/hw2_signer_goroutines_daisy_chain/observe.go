@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type EventKind int
+
+const (
+	StageStart EventKind = iota
+	StageEnd
+	ItemIn  // the stage took a value
+	ItemOut // the stage gave a value and it is in the next channel
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case StageStart:
+		return "stage-start"
+	case StageEnd:
+		return "stage-end"
+	case ItemIn:
+		return "item-in"
+	case ItemOut:
+		return "item-out"
+	}
+	return "event-" + strconv.Itoa(int(k))
+}
+
+// Event is what an Observer gets from an observed stage
+type Event struct {
+	Kind  EventKind
+	Stage string
+	Time  time.Time
+	// Queue is the values left in the input channel for ItemIn and waiting in the output channel for ItemOut,
+	// it stays at the capacity of the channel in front of a bottleneck
+	Queue int
+	// Latency of ItemOut is the time since the stage took the oldest value it has not given back yet,
+	// that is the time of the item for the stages giving one value for one value in the order they took them.
+	// For the others it is right only on average.
+	Latency time.Duration
+	// Unordered of ItemOut tells the stage of ObservedUnordered, its values go out in another order
+	Unordered bool
+	Err       error // of StageEnd
+	// Channels of StageStart tells the fill of the channels of the stage at any time while it runs
+	Channels func() ChannelState
+}
+
+// ChannelState is the fill of the channels around a stage, a full one is blocked on the reader
+type ChannelState struct {
+	In, InCap   int
+	Out, OutCap int
+}
+
+// Observer gets the events of the observed stages, from many goroutines at once
+type Observer interface {
+	Observe(e Event)
+}
+
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// Observers sends every event to all of obs
+func Observers(obs ...Observer) Observer {
+	return ObserverFunc(func(e Event) {
+		for _, o := range obs {
+			o.Observe(e)
+		}
+	})
+}
+
+// Observed gives the events of the stage to obs under the name
+func Observed[In, Out any](name string, obs Observer, stage Stage[In, Out]) Stage[In, Out] {
+	return observed(name, obs, stage, false)
+}
+
+// ObservedUnordered is Observed of a stage giving the values out of the order it took them, like FanOut,
+// its Event.Latency is not the one of the item
+func ObservedUnordered[In, Out any](name string, obs Observer, stage Stage[In, Out]) Stage[In, Out] {
+	return observed(name, obs, stage, true)
+}
+
+func observed[In, Out any](name string, obs Observer, stage Stage[In, Out], unordered bool) Stage[In, Out] {
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		channels := func() ChannelState {
+			return ChannelState{In: len(in), InCap: cap(in), Out: len(out), OutCap: cap(out)}
+		}
+		obs.Observe(Event{Kind: StageStart, Stage: name, Time: time.Now(), Channels: channels})
+		mu := &sync.Mutex{}
+		var taken []time.Time // of the values not given back yet
+
+		// the values left in when the stage returns are read by the caller, like for any stage
+		observedIn := make(chan In, 1)
+		stop := make(chan struct{})
+		go func() {
+			defer close(observedIn)
+			for {
+				var val In
+				var ok bool
+				select {
+				case val, ok = <-in:
+				case <-stop:
+				}
+				if !ok {
+					return
+				}
+				now := time.Now()
+				mu.Lock()
+				taken = append(taken, now)
+				mu.Unlock()
+				obs.Observe(Event{Kind: ItemIn, Stage: name, Time: now, Queue: len(in)})
+				select {
+				case observedIn <- val:
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		observedOut := make(chan Out, 1)
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for val := range observedOut {
+				now := time.Now()
+				var latency time.Duration
+				mu.Lock()
+				if len(taken) > 0 {
+					latency = now.Sub(taken[0])
+					taken = taken[1:]
+				}
+				mu.Unlock()
+				if send(ctx, out, val) != nil {
+					continue // the stage must not block on its output
+				}
+				obs.Observe(Event{Kind: ItemOut, Stage: name, Time: now, Queue: len(out), Latency: latency, Unordered: unordered})
+			}
+		}()
+
+		err := stage.run(ctx, observedIn, observedOut)
+		close(observedOut)
+		<-sent
+		close(stop)
+		drain(observedIn)
+		obs.Observe(Event{Kind: StageEnd, Stage: name, Time: time.Now(), Err: err})
+		return err
+	}
+}
+
+// ObserveJobs names the jobs of ExecutePipelineContext "job 0", "job 1"... for obs
+func ObserveJobs(obs Observer, jobs ...ctxJob) []ctxJob {
+	observed := make([]ctxJob, 0, len(jobs))
+	for n, function := range jobs {
+		observed = append(observed, Observed("job "+strconv.Itoa(n), obs, function))
+	}
+	return observed
+}
+
+// StageStats sums up the events of one stage
+type StageStats struct {
+	Name       string
+	Start, End time.Time
+	In, Out    int
+	Queue      int // sum of Event.Queue of ItemIn
+	MaxQueue   int
+	Latency    time.Duration // sum of Event.Latency of ItemOut
+	MaxLatency time.Duration // 0 for Unordered
+	Unordered  bool          // the stage of ObservedUnordered, only the average latency is known
+	Err        error
+}
+
+func (s StageStats) AvgLatency() time.Duration {
+	if s.Out == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Out)
+}
+
+func (s StageStats) AvgQueue() float64 {
+	if s.In == 0 {
+		return 0
+	}
+	return float64(s.Queue) / float64(s.In)
+}
+
+// Report is an Observer collecting StageStats, the zero value is ready to use
+type Report struct {
+	mu     sync.Mutex
+	stages []*StageStats // in the order of the first event
+	byName map[string]*StageStats
+}
+
+func (r *Report) Observe(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.byName[e.Stage]
+	if !ok {
+		if r.byName == nil {
+			r.byName = map[string]*StageStats{}
+		}
+		s = &StageStats{Name: e.Stage}
+		r.byName[e.Stage] = s
+		r.stages = append(r.stages, s)
+	}
+	switch e.Kind {
+	case StageStart:
+		s.Start = e.Time
+	case StageEnd:
+		s.End, s.Err = e.Time, e.Err
+	case ItemIn:
+		s.In++
+		s.Queue += e.Queue
+		s.MaxQueue = max(s.MaxQueue, e.Queue)
+	case ItemOut:
+		s.Out++
+		s.Latency += e.Latency
+		if e.Unordered {
+			s.Unordered = true
+		} else {
+			s.MaxLatency = max(s.MaxLatency, e.Latency)
+		}
+	}
+}
+
+func (r *Report) Stats() []StageStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]StageStats, 0, len(r.stages))
+	for _, s := range r.stages {
+		stats = append(stats, *s)
+	}
+	return stats
+}
+
+// Bottleneck is the stage with the longest average latency, the values queue up in front of it
+func (r *Report) Bottleneck() (StageStats, bool) {
+	var slowest StageStats
+	found := false
+	for _, s := range r.Stats() {
+		if !found || s.AvgLatency() > slowest.AvgLatency() {
+			slowest, found = s, true
+		}
+	}
+	return slowest, found
+}
+
+// WriteTo prints a table of the stages and the bottleneck
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "stage\tin\tout\ttime\tavg queue\tmax queue\tavg latency\tmax latency\terror")
+	for _, s := range r.Stats() {
+		var took time.Duration
+		if !s.End.IsZero() {
+			took = s.End.Sub(s.Start)
+		}
+		errText := "-"
+		if s.Err != nil {
+			errText = s.Err.Error()
+		}
+		maxLatency := "-"
+		if !s.Unordered {
+			maxLatency = s.MaxLatency.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%.2f\t%d\t%v\t%s\t%s\n", s.Name, s.In, s.Out,
+			took.Round(time.Microsecond), s.AvgQueue(), s.MaxQueue,
+			s.AvgLatency().Round(time.Microsecond), maxLatency, errText)
+	}
+	tw.Flush()
+	if slowest, ok := r.Bottleneck(); ok {
+		fmt.Fprintf(buf, "bottleneck: %s\n", slowest.Name)
+	}
+	return buf.WriteTo(w)
+}
+
+// ExpvarObserver publishes the counters of every stage in a map of an expvar.Map,
+// they are served at /debug/vars with net/http
+type ExpvarObserver struct {
+	mu   sync.Mutex
+	vars *expvar.Map
+}
+
+// NewExpvarObserver publishes the map under the name, the map published before is taken again
+func NewExpvarObserver(name string) *ExpvarObserver {
+	if v, ok := expvar.Get(name).(*expvar.Map); ok {
+		return &ExpvarObserver{vars: v}
+	}
+	return &ExpvarObserver{vars: expvar.NewMap(name)}
+}
+
+func (o *ExpvarObserver) stage(name string) *expvar.Map {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if v, ok := o.vars.Get(name).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map).Init()
+	o.vars.Set(name, v)
+	return v
+}
+
+func (o *ExpvarObserver) Observe(e Event) {
+	v := o.stage(e.Stage)
+	switch e.Kind {
+	case StageStart:
+		v.Add("running", 1)
+	case StageEnd:
+		v.Add("running", -1)
+		if e.Err != nil {
+			v.Add("errors", 1)
+		}
+	case ItemIn:
+		v.Add("items_in", 1)
+		queue := new(expvar.Int)
+		queue.Set(int64(e.Queue)) // the last one seen
+		v.Set("queue_in", queue)
+	case ItemOut:
+		v.Add("items_out", 1)
+		v.Add("latency_ns", int64(e.Latency))
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Stage reads In values from in and writes Out values to out, it stops when ctx is done.
+// in is closed by the stage before, out is closed after the stage returns.
+type Stage[In, Out any] func(ctx context.Context, in chan In, out chan Out) error
+
+// ctxJob is a job which stops when ctx is done and can fail
+type ctxJob = Stage[interface{}, interface{}]
+
+// ExecutePipeline runs the jobs with no way to stop them, a panic of a job goes on in the caller
+func ExecutePipeline(freeFlowJobs ...job) {
+	jobs := make([]ctxJob, 0, len(freeFlowJobs))
+	for _, function := range freeFlowJobs {
+		jobs = append(jobs, withContext(function))
+	}
+	if err := ExecutePipelineContext(context.Background(), jobs...); err != nil {
+		panic(err)
+	}
+}
+
+// BufferedJob is a job of ExecutePipelineBuffered with the buffer of its output
+type BufferedJob struct {
+	Job    ctxJob
+	Buffer *Buffer // nil is the channel of 1 value of ExecutePipelineContext
+}
+
+// ExecutePipelineBuffered is ExecutePipelineContext with the output of every job sized on its own,
+// a heavy job can get ahead of the next one or make room for the one before it
+func ExecutePipelineBuffered(ctx context.Context, jobs ...BufferedJob) error {
+	buffered := make([]ctxJob, 0, len(jobs))
+	for _, j := range jobs {
+		if j.Buffer != nil {
+			j.Job = Buffered(j.Buffer, j.Job)
+		}
+		buffered = append(buffered, j.Job)
+	}
+	return ExecutePipelineContext(ctx, buffered...)
+}
+
+func withContext(function job) ctxJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		function(in, out)
+		return nil
+	}
+}
+
+// ExecutePipelineContext runs the jobs chained by channels and waits for all of them.
+// The first error or panic of a job cancels ctx of the others and is returned.
+func ExecutePipelineContext(ctx context.Context, jobs ...ctxJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	stage := numbered(0, jobs[0])
+	for n := 1; n < len(jobs); n++ {
+		stage = Then(stage, numbered(n, jobs[n]))
+	}
+	return runStage(ctx, stage, nil, nil) // the values of the last job are dropped
+}
+
+// numbered tells which job panicked
+func numbered(n int, function ctxJob) ctxJob {
+	return func(ctx context.Context, in, out chan interface{}) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job %d panicked: %v", n, r)
+			}
+		}()
+		return function(ctx, in, out)
+	}
+}
+
+// cancelKey keeps the cancel of the pipeline in its ctx
+type cancelKey struct{}
+
+// newPipeline gives the ctx and the cancel shared by all the stages of a pipeline
+func newPipeline(ctx context.Context) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return context.WithValue(ctx, cancelKey{}, cancel), cancel
+}
+
+// pipeline gives the ctx and the cancel of the pipeline the stage runs in,
+// so the first error cancels every stage at once and not only the ones next to it.
+// A stage run on its own starts a new pipeline, owned tells it must be cancelled at the end.
+func pipeline(ctx context.Context) (_ context.Context, _ context.CancelCauseFunc, owned bool) {
+	if cancel, ok := ctx.Value(cancelKey{}).(context.CancelCauseFunc); ok {
+		return ctx, cancel, false
+	}
+	ctx, cancel := newPipeline(ctx)
+	return ctx, cancel, true
+}
+
+// Then makes one stage of two, the values of first go to second.
+// The first error of the two cancels ctx of the whole pipeline and is returned.
+func Then[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in chan A, out chan C) error {
+		ctx, cancel, owned := pipeline(ctx)
+		if owned {
+			defer cancel(nil)
+		}
+		mid, done := first.start(ctx, cancel, in)
+		if err := second.run(ctx, mid, out); err != nil {
+			cancel(err)
+		}
+		drain(mid)
+		<-done
+		return failure(ctx)
+	}
+}
+
+// Run feeds values to the stage and gives all it writes
+func Run[In, Out any](ctx context.Context, stage Stage[In, Out], values ...In) ([]Out, error) {
+	var results []Out
+	err := runStage(ctx, stage, values, func(val Out) {
+		results = append(results, val)
+	})
+	return results, err
+}
+
+func runStage[In, Out any](ctx context.Context, stage Stage[In, Out], values []In, collect func(Out)) error {
+	ctx, cancel := newPipeline(ctx)
+	defer cancel(nil)
+	in := make(chan In, 1)
+	go func() {
+		defer close(in)
+		for _, val := range values {
+			if send(ctx, in, val) != nil {
+				return
+			}
+		}
+	}()
+	out, done := stage.start(ctx, cancel, in)
+	for val := range out {
+		if collect != nil {
+			collect(val)
+		}
+	}
+	<-done
+	return failure(ctx)
+}
+
+func (s Stage[In, Out]) run(ctx context.Context, in chan In, out chan Out) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("stage panicked: %v", r)
+		}
+	}()
+	return s(ctx, in, out)
+}
+
+// start runs the stage in a goroutine, its error cancels ctx.
+// done is closed when out is closed and in is read up to the end.
+func (s Stage[In, Out]) start(ctx context.Context, cancel context.CancelCauseFunc, in chan In) (chan Out, chan struct{}) {
+	out := make(chan Out, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer drain(in)
+		defer close(out)
+		if err := s.run(ctx, in, out); err != nil {
+			cancel(err)
+		}
+	}()
+	return out, done
+}
+
+// failure is the first error of the stages or why the parent ctx is done
+func failure(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
+
+// Untyped makes a job of the stage for ExecutePipeline.
+// A value of a wrong type or an error of the stage panics in the job, like a failed type assertion did.
+func Untyped[In, Out any](stage Stage[In, Out]) job {
+	return func(in, out chan interface{}) {
+		typedIn, typedOut := make(chan In, 1), make(chan Out, 1)
+		var wrongType error
+		go func() {
+			defer close(typedIn)
+			for val := range in {
+				typed, ok := val.(In)
+				if !ok {
+					wrongType = fmt.Errorf("wrong type %T of the value %v", val, val)
+					return
+				}
+				typedIn <- typed
+			}
+		}()
+		errc := make(chan error, 1)
+		go func() {
+			defer close(typedOut)
+			err := stage.run(context.Background(), typedIn, typedOut)
+			drain(typedIn) // wrongType is set once typedIn is closed
+			errc <- err
+		}()
+		for val := range typedOut {
+			out <- val
+		}
+		err := <-errc
+		if wrongType != nil {
+			panic(wrongType)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// drain reads in up to the end, so the stage before a stopped one does not block on sending
+func drain[T any](in chan T) {
+	for range in {
+	}
+}
+
+// send is out <- val unless ctx is done first
+func send[T any](ctx context.Context, out chan T, val T) error {
+	select {
+	case out <- val:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// protected is fn(ctx, val) with its panic as the error, a worker goroutine can't take the process down
+func protected[In, Out any](ctx context.Context, fn func(ctx context.Context, val In) (Out, error), val In) (res Out, err error) {
+	defer recovered(&err)
+	return fn(ctx, val)
+}
+
+// recovered keeps the panic of the goroutine in err, it must be deferred itself
+func recovered(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("panicked: %v", r)
+	}
+}
+
+// FanOutConfig tunes the stages of FanOut
+type FanOutConfig struct {
+	Ordered  bool // the results go out in the order of the input, not as soon as they are ready
+	InFlight int  // values taken from the input and not sent out yet, 0 means no limit
+}
+
+type sequenced[T any] struct {
+	seq int
+	val T
+	err error
+}
+
+// FanOut makes a stage calling fn for every value in its own goroutine.
+// In the ordered mode the results wait in a reorder buffer for the ones before them,
+// InFlight bounds that buffer as well, a slot is freed only when a result is sent.
+func FanOut[In, Out any](cfg FanOutConfig, fn func(ctx context.Context, val In) (Out, error)) Stage[In, Out] {
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		ctx, cancel, owned := pipeline(ctx)
+		if owned {
+			defer cancel(nil)
+		}
+		var slots chan struct{}
+		if cfg.InFlight > 0 {
+			slots = make(chan struct{}, cfg.InFlight)
+		}
+		release := func() {
+			if slots != nil {
+				<-slots
+			}
+		}
+
+		results := make(chan sequenced[Out])
+		go func() {
+			wg := &sync.WaitGroup{}
+			defer close(results)
+			defer wg.Wait()
+			seq := 0
+			for val := range in {
+				if slots != nil && send(ctx, slots, struct{}{}) != nil {
+					return
+				}
+				wg.Add(1)
+				go func(seq int, val In) {
+					defer wg.Done()
+					res, err := protected(ctx, fn, val)
+					if err != nil {
+						cancel(err)
+					}
+					results <- sequenced[Out]{seq: seq, val: res, err: err}
+				}(seq, val)
+				seq++
+			}
+		}()
+
+		pending := map[int]Out{}
+		next := 0
+		for r := range results {
+			switch {
+			case r.err != nil || ctx.Err() != nil:
+				release() // the rest is only read up to the end
+			case !cfg.Ordered:
+				send(ctx, out, r.val)
+				release()
+			default:
+				pending[r.seq] = r.val
+				for val, ok := pending[next]; ok; val, ok = pending[next] {
+					delete(pending, next)
+					send(ctx, out, val)
+					release()
+					next++
+				}
+			}
+		}
+		return failure(ctx)
+	}
+}
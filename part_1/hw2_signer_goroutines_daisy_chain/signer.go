@@ -1,164 +1,124 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
 )
 
-type channels struct {
-	in  chan interface{}
-	out chan interface{}
-}
+var (
+	SingleHash     = Untyped(SingleHashStage)
+	MultiHash      = Untyped(MultiHashStage)
+	CombineResults = Untyped(CombineResultsStage)
 
-func ExecutePipeline(freeFlowJobs ...job) {
-	wg := &sync.WaitGroup{}
-	chnl := channels{in: make(chan interface{}, 1)}
+	// DataSignerMd5 can't run twice at once, it overheats.
+	// DataSignerCrc32 has no limit, the task wants all of them at once to fit in the time.
+	defaultLimits   = Limits{Md5: NewSemaphore(1)}
+	SingleHashStage = NewSingleHash(FanOutConfig{}, defaultLimits)
+	MultiHashStage  = NewMultiHash(FanOutConfig{}, defaultLimits)
 
-	for n := range freeFlowJobs {
-		chnl.out = make(chan interface{}, 1)
-		wg.Add(1)
-		go func(function job, chnl channels, wg *sync.WaitGroup) {
-			function(chnl.in, chnl.out)
-			close(chnl.out)
-			wg.Done()
-		}(freeFlowJobs[n], chnl, wg)
-		chnl.in = chnl.out
-	}
-	wg.Wait()
+	// traceOut gets the values going through the stages
+	traceOut io.Writer = os.Stdout
+)
+
+// NewSingleHash is SingleHash of DefaultSignerConfig with cfg and limits
+func NewSingleHash(cfg FanOutConfig, limits Limits) Stage[int, string] {
+	c := DefaultSignerConfig
+	c.FanOut, c.Limits = cfg, limits
+	single, _, _ := c.Stages() // the default config is valid
+	return single
 }
 
-func SingleHash(in, out chan interface{}) {
-	wg := &sync.WaitGroup{}
-	start := time.Now()
+func (c SignerConfig) singleHash(ctx context.Context, value int) (string, error) {
+	return c.singleHashOf(ctx, strconv.Itoa(value))
+}
 
-	for val := range in {
-		time.Sleep(11 * time.Millisecond)
-		transit := val.(int)
-		wg.Add(1)
-		go func(value int, wg *sync.WaitGroup) {
-			fmt.Println(value, "SingleHash data", value)
+// singleHashOf joins the chains of c.SingleHash with ~, they go at once
+func (c SignerConfig) singleHashOf(ctx context.Context, data string) (string, error) {
+	fmt.Fprintln(traceOut, data, "SingleHash data", data)
 
+	hashes := make([]string, len(c.SingleHash))
+	errs := make([]error, len(c.SingleHash))
+	wg := &sync.WaitGroup{}
+	for n, chain := range c.SingleHash {
+		wg.Add(1)
+		go func(n int, chain []string) {
 			defer wg.Done()
-			valInt := strconv.Itoa(value)
-			var hashTotal string
-			hash0 := make(chan string)
-			hash1 := make(chan string)
-			hash2 := make(chan string)
-
-			go func() {
-				hash0 <- DataSignerMd5(valInt)
-			}()
-			go func() {
-				hash1 <- DataSignerCrc32(valInt)
-			}()
-			go func() {
-				hash2 <- DataSignerCrc32(<-hash0)
-			}()
-			hashTotal = <-hash1 + "~" + <-hash2
-			fmt.Println(value, "SingleHash result", hashTotal)
-			out <- hashTotal
-		}(transit, wg)
+			defer recovered(&errs[n])
+			hashes[n], errs[n] = c.chain(ctx, chain, data)
+		}(n, chain)
 	}
 	wg.Wait()
-	end := time.Since(start)
-	fmt.Println("SingleHash dur:", end)
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
 
+	hashTotal := strings.Join(hashes, "~")
+	fmt.Fprintln(traceOut, data, "SingleHash result", hashTotal)
+	return hashTotal, nil
 }
 
-func MultiHash(in, out chan interface{}) {
-	wg := &sync.WaitGroup{}
-	start := time.Now()
+func (c SignerConfig) chain(ctx context.Context, chain []string, data string) (string, error) {
+	for _, name := range chain {
+		var err error
+		if data, err = c.Limits.sign(ctx, name, data); err != nil {
+			return "", err
+		}
+	}
+	return data, nil
+}
 
-	for val := range in {
-		transit := val.(string)
-		fmt.Println("MultiHash started", transit)
+// NewMultiHash is MultiHash of DefaultSignerConfig with cfg and limits
+func NewMultiHash(cfg FanOutConfig, limits Limits) Stage[string, string] {
+	c := DefaultSignerConfig
+	c.FanOut, c.Limits = cfg, limits
+	_, multi, _ := c.Stages()
+	return multi
+}
+
+// multiHash concatenates c.MultiHash(th+data) for th 0..c.Width-1, all of them go at once
+func (c SignerConfig) multiHash(ctx context.Context, value string) (string, error) {
+	fmt.Fprintln(traceOut, "MultiHash started", value)
+	result := make([]string, c.Width)
+	errs := make([]error, c.Width)
+	wg := &sync.WaitGroup{}
+	for th := range result {
 		wg.Add(1)
-		go func(value string, wg *sync.WaitGroup) {
+		go func(th int) {
 			defer wg.Done()
-			multi := make(chan string, 6)
-			for th := 0; th < 6; th++ {
-				go func(th int, value string) {
-					thstr := strconv.Itoa(th)
-					multi <- thstr + DataSignerCrc32(thstr+value)
-				}(th, value)
-			}
-
-			result := make([]string, 6)
-			counter := 0
-			for v := range multi {
-				n, _ := strconv.ParseInt(v[:1], 10, 10)
-				result[n] = v[1:]
-				counter++
-				if counter == 6 {
-					close(multi)
-				}
-			}
-
-			var forOut string
-			for n := range result {
-				forOut += result[n]
-			}
-			out <- forOut
-			fmt.Println("MultiHash", value, "result:\n", forOut)
-		}(transit, wg)
+			defer recovered(&errs[th])
+			result[th], errs[th] = c.Limits.sign(ctx, c.MultiHash, strconv.Itoa(th)+value)
+		}(th)
 	}
 	wg.Wait()
-	end := time.Since(start)
-	fmt.Println("MultiHash time used:", end)
-}
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
 
-func CombineResults(in, out chan interface{}) {
-	start := time.Now()
+	forOut := strings.Join(result, "")
+	fmt.Fprintln(traceOut, "MultiHash", value, "result:\n", forOut)
+	return forOut, nil
+}
 
+func CombineResultsStage(ctx context.Context, in chan string, out chan string) error {
 	combined := make([]string, 0)
 	i := 0
 	for val := range in {
-		combined = append(combined, val.(string))
-		fmt.Println("Combined: ", strconv.Itoa(i), combined)
+		combined = append(combined, val)
+		fmt.Fprintln(traceOut, "Combined: ", strconv.Itoa(i), combined)
 	}
-	fmt.Println("Comb TIME:", time.Since(start))
-
-	sort.SliceStable(combined, func(i, j int) bool {
-		return combined[i] < combined[j]
-	})
-
-	var result string
-	for n, _ := range combined {
-		if n == 0 {
-			result = combined[n]
-		} else {
-			result += "_" + combined[n]
-		}
-	}
-	out <- result
+
+	return send(ctx, out, joinSorted(combined))
 }
 
-//func main() {
-//	slicedLine := []int{0, 1, 1, 2, 3, 5, 8}
-//
-//	freeFlowJobs := []job{
-//		job(func(in, out chan interface{}) {
-//			for _, val := range slicedLine {
-//				out <- val
-//			}
-//		}),
-//		job(SingleHash),
-//		job(MultiHash),
-//		job(CombineResults),
-//		job(func(in, out chan interface{}) {
-//			dataRaw := <-in
-//			data, ok := dataRaw.(string)
-//			if !ok {
-//				fmt.Errorf("cant convert result data to string")
-//			}
-//			fmt.Println("YRA! \n", data)
-//		}),
-//	}
-//
-//	start := time.Now()
-//	ExecutePipeline(freeFlowJobs...)
-//	fmt.Println("time: ", time.Since(start))
-//}
+// joinSorted is the values sorted and joined with _
+func joinSorted(values []string) string {
+	sort.Strings(values)
+	return strings.Join(values, "_")
+}
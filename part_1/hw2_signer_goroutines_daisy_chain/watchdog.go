@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type watchedStage struct {
+	name     string
+	running  bool
+	in, out  int
+	last     time.Time // of the last event
+	channels func() ChannelState
+}
+
+// Watchdog is an Observer calling onStall with the state of the stages
+// when no value goes in or out of a running stage for stall
+type Watchdog struct {
+	mu      sync.Mutex
+	stall   time.Duration
+	onStall func(dump string)
+	moved   time.Time
+	dumped  bool // for this stall already
+	stages  []*watchedStage
+	byName  map[string]*watchedStage
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewWatchdog starts watching, Stop ends it
+func NewWatchdog(stall time.Duration, onStall func(dump string)) *Watchdog {
+	w := &Watchdog{
+		stall:   stall,
+		onStall: onStall,
+		moved:   time.Now(),
+		byName:  map[string]*watchedStage{},
+		stop:    make(chan struct{}),
+	}
+	go w.watch()
+	return w
+}
+
+func (w *Watchdog) Observe(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.byName[e.Stage]
+	if !ok {
+		s = &watchedStage{name: e.Stage}
+		w.byName[e.Stage] = s
+		w.stages = append(w.stages, s)
+	}
+	s.last = e.Time
+	switch e.Kind {
+	case StageStart:
+		s.running, s.channels = true, e.Channels
+	case StageEnd:
+		s.running, s.channels = false, nil
+	case ItemIn:
+		s.in++
+	case ItemOut:
+		s.out++
+	}
+	w.moved, w.dumped = e.Time, false
+}
+
+func (w *Watchdog) watch() {
+	ticker := time.NewTicker(max(w.stall/4, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			if dump, ok := w.stalled(now); ok {
+				w.onStall(dump)
+			}
+		}
+	}
+}
+
+func (w *Watchdog) stalled(now time.Time) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dumped || now.Sub(w.moved) < w.stall {
+		return "", false
+	}
+	for _, s := range w.stages {
+		if s.running {
+			w.dumped = true
+			return w.dump(now), true
+		}
+	}
+	return "", false
+}
+
+// Dump tells for every stage the values in and out, the values inside it and the fill of its channels
+func (w *Watchdog) Dump() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dump(time.Now())
+}
+
+func (w *Watchdog) dump(now time.Time) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "no value moved for %v\n", now.Sub(w.moved).Round(time.Millisecond))
+	for _, s := range w.stages {
+		state := "done"
+		if s.running {
+			state = "running"
+		}
+		fmt.Fprintf(b, "%s: %s, %d in, %d out, %d inside, last event %v ago", s.name, state, s.in, s.out,
+			s.in-s.out, now.Sub(s.last).Round(time.Millisecond))
+		if s.channels != nil {
+			c := s.channels()
+			fmt.Fprintf(b, ", in chan %d/%d, out chan %d/%d", c.In, c.InCap, c.Out, c.OutCap)
+			if c.InCap > 0 && c.In == c.InCap {
+				b.WriteString(", not reading")
+			}
+			if c.OutCap > 0 && c.Out == c.OutCap {
+				b.WriteString(", blocked on the next stage")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (w *Watchdog) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}